@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/tmnhat2001/worker-service/internal/worker"
+)
+
+// AttachExit describes how the job of an Attachment has ended
+type AttachExit struct {
+	Status       worker.JobState
+	StatusReason string
+	ExitReason   worker.ExitReason
+	ExitCode     *int
+	Signal       string
+	CoreDumped   bool
+}
+
+// Attachment is a connection to the terminal of a job started with TTY, opened by Attach.
+// Closing it detaches from the job, which keeps running. Writes are safe for concurrent use.
+type Attachment struct {
+	conn  *websocket.Conn
+	mutex sync.Mutex
+}
+
+// Attach calls the /jobs/{id}/attach endpoint of the Worker API to attach to the terminal of a job
+func (api *WorkerAPI) Attach(jobID string) (*Attachment, error) {
+	url := "wss" + strings.TrimPrefix(endpoint, "https") + "/jobs/" + jobID + "/attach"
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+	request.SetBasicAuth(api.config.Username, api.config.Password)
+
+	conn, response, err := api.dialer.Dial(url, request.Header)
+	if err == websocket.ErrBadHandshake && response != nil {
+		defer response.Body.Close()
+		return nil, errorFromResponse(response)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Error sending request")
+	}
+
+	return &Attachment{conn: conn}, nil
+}
+
+// ReadOutput returns the next output of the job. Once the job has finished, returns how it ended instead.
+// The output written before attaching ends with the last output the job has written.
+func (attachment *Attachment) ReadOutput() ([]byte, *AttachExit, error) {
+	for {
+		messageType, p, err := attachment.conn.ReadMessage()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if messageType == websocket.BinaryMessage {
+			return p, nil, nil
+		}
+
+		var message struct {
+			Type string
+			AttachExit
+		}
+		err = json.Unmarshal(p, &message)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Unable to parse message")
+		}
+
+		if message.Type == "exit" {
+			return nil, &message.AttachExit, nil
+		}
+	}
+}
+
+// Write sends p to the terminal of the job, as if it were typed on it
+func (attachment *Attachment) Write(p []byte) (int, error) {
+	attachment.mutex.Lock()
+	defer attachment.mutex.Unlock()
+
+	err := attachment.conn.WriteMessage(websocket.BinaryMessage, p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Resize changes the size of the terminal of the job
+func (attachment *Attachment) Resize(rows, cols int) error {
+	attachment.mutex.Lock()
+	defer attachment.mutex.Unlock()
+
+	return attachment.conn.WriteJSON(map[string]interface{}{"Type": "resize", "Rows": rows, "Cols": cols})
+}
+
+// Close detaches from the job
+func (attachment *Attachment) Close() error {
+	attachment.mutex.Lock()
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Detached")
+	attachment.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(requestTimeout))
+	attachment.mutex.Unlock()
+
+	return attachment.conn.Close()
+}
@@ -7,17 +7,26 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+	"github.com/tmnhat2001/worker-service/internal/worker"
 )
 
-// WorkerAPI provides a client-side implementation to call the Worker API
+// WorkerAPI provides a client-side implementation to call the Worker API.
+// streamClient has no timeout, for the responses streamed as long as a job is running.
+// dialer opens the WebSockets, with the same TLS configuration as the clients.
 type WorkerAPI struct {
-	config WorkerAPIConfig
-	client *http.Client
+	config       WorkerAPIConfig
+	client       *http.Client
+	streamClient *http.Client
+	dialer       *websocket.Dialer
 }
 
 const (
@@ -32,9 +41,12 @@ func NewWorkerAPI(config WorkerAPIConfig) (*WorkerAPI, error) {
 		return nil, err
 	}
 
+	tlsConfig := client.Transport.(*http.Transport).TLSClientConfig
 	return &WorkerAPI{
-		config: config,
-		client: client,
+		config:       config,
+		client:       client,
+		streamClient: &http.Client{Transport: client.Transport},
+		dialer:       &websocket.Dialer{TLSClientConfig: tlsConfig, HandshakeTimeout: requestTimeout},
 	}, nil
 }
 
@@ -92,10 +104,8 @@ func errorFromResponse(response *http.Response) error {
 }
 
 // StartJob calls the /start endpoint of the Worker API
-func (api *WorkerAPI) StartJob(command string) ([]byte, error) {
-	requestBody, err := json.Marshal(map[string]string{
-		"Command": command,
-	})
+func (api *WorkerAPI) StartJob(jobRequest StartJobRequest) ([]byte, error) {
+	requestBody, err := json.Marshal(jobRequest)
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to create request body")
 	}
@@ -109,11 +119,18 @@ func (api *WorkerAPI) StartJob(command string) ([]byte, error) {
 	return api.executeRequest(request)
 }
 
-// StopJob calls the /stop endpoint of the Worker API
-func (api *WorkerAPI) StopJob(jobID string) ([]byte, error) {
-	requestBody, err := json.Marshal(map[string]string{
-		"ID": jobID,
-	})
+// StopJob calls the /stop endpoint of the Worker API.
+// The job is sent SIGKILL after the grace period, or right away if force is set. A zero grace period uses the server's default.
+func (api *WorkerAPI) StopJob(jobID string, gracePeriod time.Duration, force bool) ([]byte, error) {
+	jobRequest := map[string]interface{}{
+		"ID":    jobID,
+		"Force": force,
+	}
+	if gracePeriod > 0 {
+		jobRequest["GracePeriod"] = gracePeriod.String()
+	}
+
+	requestBody, err := json.Marshal(jobRequest)
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to create request body")
 	}
@@ -127,6 +144,36 @@ func (api *WorkerAPI) StopJob(jobID string) ([]byte, error) {
 	return api.executeRequest(request)
 }
 
+// PauseJob calls the /pause endpoint of the Worker API
+func (api *WorkerAPI) PauseJob(jobID string) ([]byte, error) {
+	return api.jobAction("/pause", map[string]interface{}{"ID": jobID})
+}
+
+// ResumeJob calls the /resume endpoint of the Worker API
+func (api *WorkerAPI) ResumeJob(jobID string) ([]byte, error) {
+	return api.jobAction("/resume", map[string]interface{}{"ID": jobID})
+}
+
+// SignalJob calls the /signal endpoint of the Worker API to send a signal, such as "HUP", to a job
+func (api *WorkerAPI) SignalJob(jobID, signal string) ([]byte, error) {
+	return api.jobAction("/signal", map[string]interface{}{"ID": jobID, "Signal": signal})
+}
+
+// jobAction sends a PUT request with the JSON body to the path
+func (api *WorkerAPI) jobAction(path string, jobRequest map[string]interface{}) ([]byte, error) {
+	requestBody, err := json.Marshal(jobRequest)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request body")
+	}
+
+	request, err := http.NewRequest("PUT", endpoint+path, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+
+	return api.executeRequest(request)
+}
+
 // GetJob calls the /jobs endpoint of the Worker API
 func (api *WorkerAPI) GetJob(jobID string) ([]byte, error) {
 	url := endpoint + "/jobs/" + jobID
@@ -138,6 +185,114 @@ func (api *WorkerAPI) GetJob(jobID string) ([]byte, error) {
 	return api.executeRequest(request)
 }
 
+// ListJobs calls the /jobs endpoint of the Worker API to list the user's jobs matching the query.
+// The User of the query is ignored.
+func (api *WorkerAPI) ListJobs(query worker.JobQuery) ([]byte, error) {
+	values := url.Values{}
+	for _, status := range query.Statuses {
+		values.Add("status", string(status))
+	}
+	if query.Command != "" {
+		values.Set("command", query.Command)
+	}
+	times := map[string]time.Time{
+		"created_after":   query.CreatedAfter,
+		"created_before":  query.CreatedBefore,
+		"started_after":   query.StartedAfter,
+		"started_before":  query.StartedBefore,
+		"finished_after":  query.FinishedAfter,
+		"finished_before": query.FinishedBefore,
+	}
+	for name, t := range times {
+		if !t.IsZero() {
+			values.Set(name, t.Format(time.RFC3339Nano))
+		}
+	}
+	for key, value := range query.Labels {
+		if value == "" {
+			values.Add("label", key)
+		} else {
+			values.Add("label", key+"="+value)
+		}
+	}
+	if query.SortBy != "" {
+		values.Set("sort", query.SortBy)
+	}
+	if query.Order != "" {
+		values.Set("order", query.Order)
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Cursor != "" {
+		values.Set("cursor", query.Cursor)
+	}
+
+	url := endpoint + "/jobs?" + values.Encode()
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+
+	return api.executeRequest(request)
+}
+
+// StreamLogs calls the /jobs/{id}/logs endpoint of the Worker API. stream is worker.StreamStdout,
+// worker.StreamStderr or worker.StreamBoth. If follow is set, the output is read as the job writes it,
+// until the job has finished. The returned body must be closed.
+func (api *WorkerAPI) StreamLogs(jobID, stream string, follow bool) (io.ReadCloser, error) {
+	values := url.Values{}
+	values.Set("stream", stream)
+	values.Set("follow", strconv.FormatBool(follow))
+
+	url := endpoint + "/jobs/" + jobID + "/logs?" + values.Encode()
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+	request.SetBasicAuth(api.config.Username, api.config.Password)
+
+	response, err := api.streamClient.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error sending request")
+	}
+
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		return nil, errorFromResponse(response)
+	}
+
+	return response.Body, nil
+}
+
+// WriteStdin calls the /jobs/{id}/stdin endpoint of the Worker API to stream r to the stdin of a job started
+// with OpenStdin. The stdin is closed at the end of r, which sends EOF to the job, if closeStdin is set.
+func (api *WorkerAPI) WriteStdin(jobID string, r io.Reader, closeStdin bool) ([]byte, error) {
+	url := endpoint + "/jobs/" + jobID + "/stdin?close=" + strconv.FormatBool(closeStdin)
+	request, err := http.NewRequest("POST", url, r)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+	request.SetBasicAuth(api.config.Username, api.config.Password)
+
+	response, err := api.streamClient.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error sending request")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errorFromResponse(response)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading response body")
+	}
+
+	return body, nil
+}
+
 func (api *WorkerAPI) executeRequest(request *http.Request) ([]byte, error) {
 	request.SetBasicAuth(api.config.Username, api.config.Password)
 
@@ -160,6 +315,27 @@ func (api *WorkerAPI) executeRequest(request *http.Request) ([]byte, error) {
 	return body, nil
 }
 
+// StartJobRequest contains the parameters of a job started by the /start endpoint.
+// The command is given either as exact arguments in Args or as a string in Command.
+// Stdin is the input of the job. With OpenStdin instead, the input is sent with WriteStdin.
+// The job has the security profile of the user unless SecurityProfile is set.
+type StartJobRequest struct {
+	Command         string
+	Args            []string
+	ShellSplit      bool
+	Env             []string
+	CleanEnv        bool
+	WorkDir         string
+	Labels          map[string]string
+	Limits          worker.ResourceLimits
+	Isolation       string
+	SecurityProfile string `json:",omitempty"`
+	Timeout         worker.Duration
+	TTY             bool
+	Stdin           string `json:",omitempty"`
+	OpenStdin       bool
+}
+
 // WorkerAPIConfig provides configurations to set up a WorkerAPI
 type WorkerAPIConfig struct {
 	Username     string
@@ -0,0 +1,157 @@
+package wkct
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/tmnhat2001/worker-service/client/api"
+	"golang.org/x/term"
+)
+
+// detachKeys is the key sequence which detaches from a job: Ctrl-P then Ctrl-Q
+var detachKeys = []byte{0x10, 0x11}
+
+// detachFilter finds the detach keys in the input typed by the user. matched is the number of
+// keys of the sequence at the end of the input seen so far, which are held back until the sequence
+// is complete or broken.
+type detachFilter struct {
+	matched int
+}
+
+// filter returns the input to send to the job, and true once the detach keys have been typed
+func (filter *detachFilter) filter(p []byte) ([]byte, bool) {
+	var forward []byte
+	for _, b := range p {
+		if b == detachKeys[filter.matched] {
+			filter.matched++
+			if filter.matched == len(detachKeys) {
+				return forward, true
+			}
+
+			continue
+		}
+
+		forward = append(forward, detachKeys[:filter.matched]...)
+		filter.matched = 0
+		if b == detachKeys[0] {
+			filter.matched = 1
+			continue
+		}
+
+		forward = append(forward, b)
+	}
+
+	return forward, false
+}
+
+// attach connects the local terminal to the terminal of the job until the job has finished,
+// or the user detaches from it
+func (c *commandHandler) attach(jobID string) {
+	attachment, err := c.api.Attach(jobID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer attachment.Close()
+
+	restore := func() {}
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		restore = func() { term.Restore(fd, state) }
+		go sendTerminalSize(attachment, fd)
+	}
+	defer restore()
+
+	detached := make(chan struct{})
+	go sendInput(attachment, detached)
+
+	exit, err := copyOutput(attachment, detached)
+	restore()
+	switch {
+	case exit != nil:
+		fmt.Printf("Job %s has finished: %s", jobID, exit.Status)
+		if exit.ExitCode != nil {
+			fmt.Printf(", exit code %d", *exit.ExitCode)
+		}
+		if exit.Signal != "" {
+			fmt.Printf(", signal %s", exit.Signal)
+		}
+		fmt.Println()
+	case err != nil:
+		fmt.Println(err)
+	default:
+		fmt.Printf("Detached from job %s\n", jobID)
+	}
+}
+
+// copyOutput prints the output of the job until it has finished, or detached is closed
+func copyOutput(attachment *api.Attachment, detached <-chan struct{}) (*api.AttachExit, error) {
+	for {
+		output, exit, err := attachment.ReadOutput()
+		select {
+		case <-detached:
+			return nil, nil
+		default:
+		}
+
+		if err != nil || exit != nil {
+			return exit, err
+		}
+
+		os.Stdout.Write(output)
+	}
+}
+
+// sendInput sends what the user types to the job, until the detach keys are typed. detached is then
+// closed, and the attachment is closed to stop reading the output.
+func sendInput(attachment *api.Attachment, detached chan<- struct{}) {
+	var filter detachFilter
+	p := make([]byte, 1024)
+	for {
+		n, err := os.Stdin.Read(p)
+		if err != nil {
+			return
+		}
+
+		input, detach := filter.filter(p[:n])
+		if len(input) > 0 {
+			_, err = attachment.Write(input)
+			if err != nil {
+				return
+			}
+		}
+
+		if detach {
+			close(detached)
+			attachment.Close()
+			return
+		}
+	}
+}
+
+// sendTerminalSize sends the size of the local terminal to the job, then each time it changes
+func sendTerminalSize(attachment *api.Attachment, fd int) {
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	defer signal.Stop(resized)
+
+	for {
+		cols, rows, err := term.GetSize(fd)
+		if err == nil {
+			err = attachment.Resize(rows, cols)
+		}
+		if err != nil {
+			return
+		}
+
+		<-resized
+	}
+}
@@ -2,9 +2,13 @@ package wkct
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/tmnhat2001/worker-service/client/api"
+	"github.com/tmnhat2001/worker-service/internal/worker"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -58,22 +62,163 @@ func (c *CLI) Run() {
 	cli.HelpFlag.Short('h')
 
 	start := cli.Command("start", "Start a job to run the given Linux command")
-	startCommandArg := start.Arg("command", "Linux command to be run").Required().String()
+	startCommandArg := start.Arg("command", "Linux command to be run. A single argument is split following the shell quoting rules, several arguments are sent as-is").Required().Strings()
+	startEnvFlag := start.Flag("env", "Environment variable of the job in the format KEY=VALUE. Can be repeated").Short('e').Strings()
+	startCleanEnvFlag := start.Flag("clean-env", "Do not inherit the environment of the API server").Bool()
+	startWorkDirFlag := start.Flag("workdir", "Absolute path of the job's working directory").String()
+	startLabelFlag := start.Flag("label", "Label of the job in the format KEY=VALUE. Can be repeated").Short('l').StringMap()
+	startCPUMaxFlag := start.Flag("cpu-max", "Value of the job's cgroup cpu.max, e.g. \"50000 100000\"").String()
+	startMemoryMaxFlag := start.Flag("memory-max", "Value of the job's cgroup memory.max, e.g. 512M").String()
+	startIOMaxFlag := start.Flag("io-max", "Value of the job's cgroup io.max, e.g. \"8:0 rbps=1048576\"").String()
+	startPidsMaxFlag := start.Flag("pids-max", "Value of the job's cgroup pids.max").String()
+	startTimeoutFlag := start.Flag("timeout", "Time limit of the job, e.g. 5m").Duration()
+	startIsolationFlag := start.Flag("isolation", "Isolation mode of the job").Default(worker.IsolationNone).Enum(worker.IsolationNone, worker.IsolationNamespaces)
+	startSecurityProfileFlag := start.Flag("security-profile", "Security profile of the job. Defaults to the profile of the user").Enum(worker.SecurityProfiles...)
+	startTTYFlag := start.Flag("tty", "Run the job under a pseudo-terminal, to use with wkct attach").Short('t').Bool()
+	startInteractiveFlag := start.Flag("interactive", "Forward the standard input to the job, until it ends").Short('i').Bool()
 
 	stop := cli.Command("stop", "Stop a job")
 	stopCommandArg := stop.Arg("job_id", "The job ID").Required().String()
+	stopGraceFlag := stop.Flag("grace", "How long the job has to exit after SIGTERM before it is sent SIGKILL, e.g. 30s").Duration()
+	stopForceFlag := stop.Flag("force", "Send SIGKILL right away").Bool()
+
+	pause := cli.Command("pause", "Pause a running job until it is resumed")
+	pauseCommandArg := pause.Arg("job_id", "The job ID").Required().String()
+
+	resume := cli.Command("resume", "Resume a paused job")
+	resumeCommandArg := resume.Arg("job_id", "The job ID").Required().String()
+
+	signal := cli.Command("signal", "Send a signal to a job, e.g. HUP. The job keeps running unless the signal terminates it")
+	signalCommandArg := signal.Arg("job_id", "The job ID").Required().String()
+	signalNameArg := signal.Arg("signal", "The signal to send").Required().Enum(signalNames()...)
 
 	getJob := cli.Command("job", "Get the information about a job")
 	getJobCommandArg := getJob.Arg("job_id", "The job ID").Required().String()
 
+	logs := cli.Command("logs", "Print the output of a job")
+	logsCommandArg := logs.Arg("job_id", "The job ID").Required().String()
+	logsFollowFlag := logs.Flag("follow", "Keep printing the output as it is written, until the job has finished").Short('f').Bool()
+	logsStreamFlag := logs.Flag("stream", "Output stream to print").Default(worker.StreamBoth).Enum(worker.StreamStdout, worker.StreamStderr, worker.StreamBoth)
+
+	attach := cli.Command("attach", "Attach the terminal to a job started with --tty. Type Ctrl-P Ctrl-Q to detach")
+	attachCommandArg := attach.Arg("job_id", "The job ID").Required().String()
+
+	list := cli.Command("list", "List your jobs, newest first")
+	listStatusFlag := list.Flag("status", "Only list the jobs with this status. Can be repeated").Strings()
+	listCommandFlag := list.Flag("command", "Only list the jobs whose command contains this string").String()
+	listSinceFlag := list.Flag("since", "Only list the jobs created at or after this RFC3339 time").String()
+	listUntilFlag := list.Flag("until", "Only list the jobs created before this RFC3339 time").String()
+	listLabelFlag := list.Flag("label", "Only list the jobs with this label, in the format KEY=VALUE or KEY. Can be repeated").Short('l').Strings()
+	listSortFlag := list.Flag("sort", "Time by which the jobs are sorted").Default(worker.SortByCreatedAt).Enum(worker.SortByCreatedAt, worker.SortByStartedAt, worker.SortByFinishedAt)
+	listOldestFirstFlag := list.Flag("oldest-first", "List the oldest jobs first").Bool()
+	listLimitFlag := list.Flag("limit", "Number of jobs per page").Int()
+	listCursorFlag := list.Flag("cursor", "Cursor of the page to list, printed after the previous page").String()
+
 	commandHandler := &commandHandler{api: c.api}
 
 	switch kingpin.MustParse(cli.Parse(os.Args[1:])) {
 	case start.FullCommand():
-		commandHandler.startJob(*startCommandArg)
+		jobRequest := api.StartJobRequest{
+			Env:      *startEnvFlag,
+			CleanEnv: *startCleanEnvFlag,
+			WorkDir:  *startWorkDirFlag,
+			Labels:   *startLabelFlag,
+			Limits: worker.ResourceLimits{
+				CPUMax:    *startCPUMaxFlag,
+				MemoryMax: *startMemoryMaxFlag,
+				IOMax:     *startIOMaxFlag,
+				PidsMax:   *startPidsMaxFlag,
+			},
+			Isolation:       *startIsolationFlag,
+			SecurityProfile: *startSecurityProfileFlag,
+			Timeout:         worker.Duration(*startTimeoutFlag),
+			TTY:             *startTTYFlag,
+			OpenStdin:       *startInteractiveFlag,
+		}
+		setCommand(&jobRequest, *startCommandArg)
+		commandHandler.startJob(jobRequest)
 	case stop.FullCommand():
-		commandHandler.stopJob(*stopCommandArg)
+		commandHandler.stopJob(*stopCommandArg, *stopGraceFlag, *stopForceFlag)
+	case pause.FullCommand():
+		commandHandler.pauseJob(*pauseCommandArg)
+	case resume.FullCommand():
+		commandHandler.resumeJob(*resumeCommandArg)
+	case signal.FullCommand():
+		commandHandler.signalJob(*signalCommandArg, *signalNameArg)
 	case getJob.FullCommand():
 		commandHandler.getJob(*getJobCommandArg)
+	case logs.FullCommand():
+		commandHandler.streamLogs(*logsCommandArg, *logsStreamFlag, *logsFollowFlag)
+	case attach.FullCommand():
+		commandHandler.attach(*attachCommandArg)
+	case list.FullCommand():
+		query, err := listQuery(*listStatusFlag, *listCommandFlag, *listSinceFlag, *listUntilFlag, *listLabelFlag)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		query.SortBy = *listSortFlag
+		if *listOldestFirstFlag {
+			query.Order = worker.OrderOldestFirst
+		}
+		query.Limit = *listLimitFlag
+		query.Cursor = *listCursorFlag
+		commandHandler.listJobs(query)
+	}
+}
+
+// signalNames returns the names of the signals accepted by the signal command, with and without "SIG"
+func signalNames() []string {
+	var names []string
+	for _, name := range worker.AllowedSignalNames() {
+		names = append(names, name, strings.TrimPrefix(name, "SIG"))
+	}
+
+	return names
+}
+
+// listQuery returns the query of the list command from its filters
+func listQuery(statuses []string, command, since, until string, labels []string) (worker.JobQuery, error) {
+	query := worker.JobQuery{Command: command}
+	for _, status := range statuses {
+		query.Statuses = append(query.Statuses, worker.JobState(status))
 	}
+
+	var err error
+	if since != "" {
+		query.CreatedAfter, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return query, errors.New("--since must be an RFC3339 time, e.g. 2006-01-02T15:04:05Z")
+		}
+	}
+
+	if until != "" {
+		query.CreatedBefore, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			return query, errors.New("--until must be an RFC3339 time, e.g. 2006-01-02T15:04:05Z")
+		}
+	}
+
+	if len(labels) > 0 {
+		query.Labels = make(map[string]string)
+		for _, label := range labels {
+			parts := strings.SplitN(label, "=", 2)
+			query.Labels[parts[0]] = strings.Join(parts[1:], "")
+		}
+	}
+
+	return query, nil
+}
+
+// setCommand sets the command of the request. A single argument such as "ls -l" is sent as a string
+// to be split by the server, several arguments such as `-- echo "hello world"` are sent as exact arguments.
+func setCommand(jobRequest *api.StartJobRequest, args []string) {
+	if len(args) == 1 {
+		jobRequest.Command = args[0]
+		jobRequest.ShellSplit = true
+		return
+	}
+
+	jobRequest.Args = args
 }
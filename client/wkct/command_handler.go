@@ -3,8 +3,11 @@ package wkct
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"text/tabwriter"
 	"text/template"
+	"time"
 
 	"github.com/tmnhat2001/worker-service/client/api"
 	"github.com/tmnhat2001/worker-service/internal/worker"
@@ -12,24 +15,73 @@ import (
 
 const jobTemplate = `Job ID: {{.ID}}
 Command: {{.Command}}
+Env: {{.Env}}
+WorkDir: {{.WorkDir}}
 Status: {{.Status}}
-ExitCode: {{.ExitCode}}
+{{- if .StatusReason}} ({{.StatusReason}}){{end}}
+CreatedAt: {{.CreatedAt.Format "2006-01-02T15:04:05Z07:00"}}
+StartedAt: {{if .StartedAt}}{{.StartedAt.Format "2006-01-02T15:04:05Z07:00"}}{{end}}
+FinishedAt: {{if .FinishedAt}}{{.FinishedAt.Format "2006-01-02T15:04:05Z07:00"}}{{end}}
+Duration: {{.Duration}}
+Paused: {{.PausedDuration}}{{if .PausedAt}} (since {{.PausedAt.Format "2006-01-02T15:04:05Z07:00"}}){{end}}
+{{- with .Usage}}
+Usage{{if .Sampled}} (so far){{end}}: user CPU {{.UserCPU}}, system CPU {{.SystemCPU}}, max RSS {{.MaxRSS}} bytes, read {{.ReadBytes}} bytes, written {{.WriteBytes}} bytes, context switches {{.VoluntaryContextSwitches}} voluntary / {{.InvoluntaryContextSwitches}} involuntary
+{{- end}}
+ExitReason: {{.ExitReason}}
+ExitCode: {{with .ExitCode}}{{.}}{{end}}
+Signal: {{.Signal}}{{if .CoreDumped}} (core dumped){{end}}
 Stdout: {{.Stdout}}
 Stderr: {{.Stderr}}
+OutputBytes: {{.OutputBytes}}
+OutputTruncated: {{.OutputTruncated}}
 User: {{.User}}
+Credential: {{with .Credential}}uid {{.UID}}, gid {{.GID}}{{with .Groups}}, groups {{.}}{{end}}{{end}}
+Isolation: {{.Isolation}}
+SecurityProfile: {{.SecurityProfile}}
+Timeout: {{.Timeout}}
 `
 
 type commandHandler struct {
 	api *api.WorkerAPI
 }
 
-func (c *commandHandler) startJob(command string) {
-	response, err := c.api.StartJob(command)
+func (c *commandHandler) startJob(jobRequest api.StartJobRequest) {
+	response, err := c.api.StartJob(jobRequest)
 	handleResponse(response, err)
+	if err != nil || !jobRequest.OpenStdin {
+		return
+	}
+
+	var job worker.Job
+	err = json.Unmarshal(response, &job)
+	if err != nil {
+		return
+	}
+
+	// The job's stdin is closed once the standard input ends
+	_, err = c.api.WriteStdin(job.ID, os.Stdin, true)
+	if err != nil {
+		fmt.Println(err)
+	}
 }
 
-func (c *commandHandler) stopJob(jobID string) {
-	response, err := c.api.StopJob(jobID)
+func (c *commandHandler) stopJob(jobID string, gracePeriod time.Duration, force bool) {
+	response, err := c.api.StopJob(jobID, gracePeriod, force)
+	handleResponse(response, err)
+}
+
+func (c *commandHandler) pauseJob(jobID string) {
+	response, err := c.api.PauseJob(jobID)
+	handleResponse(response, err)
+}
+
+func (c *commandHandler) resumeJob(jobID string) {
+	response, err := c.api.ResumeJob(jobID)
+	handleResponse(response, err)
+}
+
+func (c *commandHandler) signalJob(jobID, signal string) {
+	response, err := c.api.SignalJob(jobID, signal)
 	handleResponse(response, err)
 }
 
@@ -38,6 +90,37 @@ func (c *commandHandler) getJob(jobID string) {
 	handleResponse(response, err)
 }
 
+func (c *commandHandler) streamLogs(jobID, stream string, follow bool) {
+	body, err := c.api.StreamLogs(jobID, stream, follow)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer body.Close()
+
+	_, err = io.Copy(os.Stdout, body)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (c *commandHandler) listJobs(query worker.JobQuery) {
+	response, err := c.api.ListJobs(query)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var page worker.JobPage
+	err = json.Unmarshal(response, &page)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	displayJobs(page)
+}
+
 func handleResponse(response []byte, err error) {
 	if err != nil {
 		fmt.Println(err)
@@ -66,3 +149,16 @@ func displayJob(job worker.Job) {
 		fmt.Println(err)
 	}
 }
+
+func displayJobs(page worker.JobPage) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "JOB ID\tSTATUS\tCREATED\tDURATION\tCOMMAND")
+	for _, job := range page.Jobs {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", job.ID, job.Status, job.CreatedAt.Format(time.RFC3339), job.Duration, job.Command)
+	}
+	writer.Flush()
+
+	if page.NextCursor != "" {
+		fmt.Printf("\nMore jobs: wkct list --cursor %s\n", page.NextCursor)
+	}
+}
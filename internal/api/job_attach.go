@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/tmnhat2001/worker-service/internal/worker"
+)
+
+// attachReplaySize is how much of the recent output of a job is sent to a client attaching to its terminal,
+// so that it sees what the terminal was showing
+const attachReplaySize = 16 * 1024
+
+// attachResize is the Type of the messages sent by attached clients when the size of their terminal changes
+const attachResize = "resize"
+
+// attachMessage is a JSON message sent by a client attached to the terminal of a job
+type attachMessage struct {
+	Type string
+	Rows uint16
+	Cols uint16
+}
+
+// binaryWriter sends the output written to it as binary messages
+type binaryWriter struct {
+	socket *jobSocket
+}
+
+func (writer binaryWriter) Write(p []byte) (int, error) {
+	err := writer.socket.sendBinary(p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// attachJob upgrades the request to a WebSocket attached to the terminal of a job started with TTY.
+// The binary messages sent by the client are written to the terminal, and its text messages are
+// attachMessages. The server sends the end of the output written so far, then the new output, as binary
+// messages. Once the job has finished, it sends an exit socketFrame and closes the socket. Several clients
+// can be attached to the same job, and a client detaches by closing the socket, which leaves the job running.
+func (server *Server) attachJob(w http.ResponseWriter, req *http.Request) {
+	user, err := userFromContext(req.Context())
+	if err != nil {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError})
+		return
+	}
+
+	requestVars := mux.Vars(req)
+	config := jobActionConfig{user: user, jobID: requestVars["jobID"]}
+	job, err := server.jobService.getJob(config)
+	if (err == errUnauthorizedUser) || (err == worker.ErrJobNotFound) {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "Failed to find job", statusCode: http.StatusNotFound})
+		return
+	} else if err != nil {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "An unexpected error has occurred", statusCode: http.StatusInternalServerError})
+		return
+	}
+
+	if !job.TTY {
+		server.requestFailed(w, req, requestError{wrappedError: worker.ErrNoTerminal, message: "The job does not have a terminal", statusCode: http.StatusConflict})
+		return
+	}
+
+	output, err := server.jobService.jobStore.FindOutput(config.jobID)
+	if err != nil {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "An unexpected error has occurred", statusCode: http.StatusInternalServerError})
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: server.checkOrigin}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// The upgrader has already returned the error to the client
+		server.logger.WithFields(logrus.Fields{"endpoint": req.URL.Path}).Warn(err)
+		return
+	}
+	defer conn.Close()
+
+	// A job which has already finished has no terminal: its output is sent, and the input is ignored
+	terminal, _ := job.Terminal()
+
+	socket := &jobSocket{conn: conn}
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	go socket.read(cancel, func(messageType int, p []byte) {
+		if terminal == nil {
+			return
+		}
+
+		// The terminal fails once the job has exited, which is not an error of the client
+		err := handleAttachMessage(terminal, messageType, p)
+		if err != nil {
+			server.logger.WithFields(logrus.Fields{"endpoint": req.URL.Path}).Debug(err)
+		}
+	})
+	go socket.keepAlive(ctx)
+
+	err = output.StreamFrom(ctx, binaryWriter{socket: socket}, worker.StreamStdout, -attachReplaySize, true)
+	if err == nil {
+		err = server.followJobStatus(ctx, config.jobID, func(worker.JobState, string) error { return nil })
+	}
+	if err == nil {
+		err = server.sendJobExit(socket, config.jobID)
+	}
+	if err != nil && ctx.Err() == nil {
+		server.logger.WithFields(logrus.Fields{"endpoint": req.URL.Path}).Error(err)
+	}
+}
+
+// handleAttachMessage writes the input sent by an attached client to the terminal, or resizes it
+func handleAttachMessage(terminal *worker.Terminal, messageType int, p []byte) error {
+	switch messageType {
+	case websocket.BinaryMessage:
+		_, err := terminal.Write(p)
+		return err
+	case websocket.TextMessage:
+		var message attachMessage
+		err := json.Unmarshal(p, &message)
+		if err != nil {
+			return err
+		}
+
+		if message.Type == attachResize && message.Rows > 0 && message.Cols > 0 {
+			return terminal.Resize(message.Rows, message.Cols)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// eventsKeepAliveInterval is how often a comment is sent on an idle event stream, so that proxies keep it open
+const eventsKeepAliveInterval = 30 * time.Second
+
+// eventReset is the event sent when some of the events following Last-Event-ID are no longer
+// in the history. The client should reload the jobs it shows.
+const eventReset = "reset"
+
+// streamEvents sends the events of the user's jobs as Server-Sent Events, until the client disconnects.
+// A client reconnecting with the Last-Event-ID header, or the "last_event_id" parameter, first receives
+// the events it has missed.
+func (server *Server) streamEvents(w http.ResponseWriter, req *http.Request) {
+	user, err := userFromContext(req.Context())
+	if err != nil {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError})
+		return
+	}
+
+	lastEventID := req.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = req.URL.Query().Get("last_event_id")
+	}
+
+	var after uint64
+	if lastEventID != "" {
+		after, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			server.requestFailed(w, req, requestError{wrappedError: err, message: "Invalid last event ID", statusCode: http.StatusBadRequest})
+			return
+		}
+	}
+
+	subscription, missed, complete := server.jobService.jobStore.Events().Subscribe(user.Username, after)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	writer := flushWriter{w}
+
+	if !complete {
+		err = writeServerEvent(writer, "", eventReset, struct{}{})
+	} else {
+		// Sends the headers, so that the client knows the stream has started
+		_, err = fmt.Fprint(writer, ": connected\n\n")
+	}
+
+	for _, event := range missed {
+		if err == nil {
+			err = writeServerEvent(writer, strconv.FormatUint(event.ID, 10), event.Type, event)
+		}
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for err == nil {
+		select {
+		case event, ok := <-subscription.Events:
+			if !ok {
+				// The client reconnects and gets the events it has missed from the history
+				server.logger.WithFields(logrus.Fields{"endpoint": req.URL.Path, "user": user.Username}).Warn("Dropped slow event stream")
+				return
+			}
+
+			err = writeServerEvent(writer, strconv.FormatUint(event.ID, 10), event.Type, event)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(writer, ": keep-alive\n\n")
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// writeServerEvent writes an event in the text/event-stream format, with its data encoded in JSON
+func writeServerEvent(w io.Writer, id, eventType string, data interface{}) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		_, err = fmt.Fprintf(w, "id: %s\n", id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, content)
+	return err
+}
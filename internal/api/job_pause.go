@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/tmnhat2001/worker-service/internal/worker"
+)
+
+// pauseJobRequest is the body of a request to the /pause and /resume endpoints
+type pauseJobRequest struct {
+	ID string
+}
+
+// pauseJob freezes the processes of a running job until it is resumed
+func (server *Server) pauseJob(req *http.Request) (interface{}, requestError) {
+	config, reqErr := decodePauseRequest(req)
+	if reqErr.wrappedError != nil {
+		return worker.Job{}, reqErr
+	}
+
+	job, err := server.jobService.pauseJob(config)
+	if (err == errUnauthorizedUser) || (err == worker.ErrJobNotFound) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to find job", statusCode: http.StatusNotFound}
+	} else if errors.Is(err, worker.ErrJobPaused) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to pause job. The job is already paused.", statusCode: http.StatusConflict}
+	} else if errors.Is(err, worker.ErrJobNotRunning) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to pause job. The job is not running.", statusCode: http.StatusConflict}
+	} else if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to pause job", statusCode: http.StatusInternalServerError}
+	}
+
+	return job, requestError{}
+}
+
+// resumeJob lets the processes of a paused job run again
+func (server *Server) resumeJob(req *http.Request) (interface{}, requestError) {
+	config, reqErr := decodePauseRequest(req)
+	if reqErr.wrappedError != nil {
+		return worker.Job{}, reqErr
+	}
+
+	job, err := server.jobService.resumeJob(config)
+	if (err == errUnauthorizedUser) || (err == worker.ErrJobNotFound) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to find job", statusCode: http.StatusNotFound}
+	} else if errors.Is(err, worker.ErrJobNotPaused) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to resume job. The job is not paused.", statusCode: http.StatusConflict}
+	} else if errors.Is(err, worker.ErrJobNotRunning) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to resume job. The job is not running.", statusCode: http.StatusConflict}
+	} else if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to resume job", statusCode: http.StatusInternalServerError}
+	}
+
+	return job, requestError{}
+}
+
+// decodePauseRequest reads the job to pause or resume from the body of the request
+func decodePauseRequest(req *http.Request) (jobActionConfig, requestError) {
+	user, err := userFromContext(req.Context())
+	if err != nil {
+		return jobActionConfig{}, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError}
+	}
+
+	var jobRequest pauseJobRequest
+	err = json.NewDecoder(req.Body).Decode(&jobRequest)
+	if err != nil {
+		return jobActionConfig{}, requestError{wrappedError: err, message: "Failed to parse request", statusCode: http.StatusBadRequest}
+	}
+
+	return jobActionConfig{user: user, jobID: jobRequest.ID}, requestError{}
+}
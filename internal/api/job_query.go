@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tmnhat2001/worker-service/internal/worker"
+)
+
+// maxListLimit is the largest number of jobs listed per page
+const maxListLimit = 500
+
+// parseJobQuery returns the query of a request to the /jobs endpoint. "status" is a status, or several
+// separated by commas, and "command" a substring of the command. "created_after", "created_before",
+// "started_after", "started_before", "finished_after" and "finished_before" are RFC3339 times. "label" is
+// "key=value", or "key" for the jobs having the label whatever its value. "status" and "label" can be repeated.
+// "sort" is "created_at" (the default), "started_at" or "finished_at", "order" is "desc" (newest first,
+// the default) or "asc", and "cursor" is the NextCursor of the previous page of "limit" jobs.
+// The error messages can be returned to the user.
+func parseJobQuery(values url.Values) (worker.JobQuery, error) {
+	query := worker.JobQuery{
+		Command: values.Get("command"),
+		Cursor:  values.Get("cursor"),
+		SortBy:  values.Get("sort"),
+		Order:   values.Get("order"),
+	}
+
+	for _, statuses := range values["status"] {
+		for _, name := range strings.Split(statuses, ",") {
+			status := worker.JobState(name)
+			if !status.Valid() {
+				return worker.JobQuery{}, fmt.Errorf("Invalid status: %s", status)
+			}
+
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+
+	times := map[string]*time.Time{
+		"created_after":   &query.CreatedAfter,
+		"created_before":  &query.CreatedBefore,
+		"started_after":   &query.StartedAfter,
+		"started_before":  &query.StartedBefore,
+		"finished_after":  &query.FinishedAfter,
+		"finished_before": &query.FinishedBefore,
+	}
+	for name, t := range times {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+
+		var err error
+		*t, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return worker.JobQuery{}, fmt.Errorf("%s must be an RFC3339 time", name)
+		}
+	}
+
+	if len(values["label"]) > 0 {
+		query.Labels = make(map[string]string)
+		for _, label := range values["label"] {
+			parts := strings.SplitN(label, "=", 2)
+			if parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+				return worker.JobQuery{}, errors.New("Labels must have the format KEY=VALUE or KEY")
+			}
+
+			query.Labels[parts[0]] = strings.Join(parts[1:], "")
+		}
+	}
+
+	switch query.SortBy {
+	case "", worker.SortByCreatedAt, worker.SortByStartedAt, worker.SortByFinishedAt:
+	default:
+		return worker.JobQuery{}, errors.New(`The sort must be "created_at", "started_at" or "finished_at"`)
+	}
+
+	switch query.Order {
+	case "", worker.OrderNewestFirst, worker.OrderOldestFirst:
+	default:
+		return worker.JobQuery{}, errors.New(`The order must be "asc" or "desc"`)
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > maxListLimit {
+			return worker.JobQuery{}, fmt.Errorf("The limit must be between 1 and %d", maxListLimit)
+		}
+	}
+
+	return query, nil
+}
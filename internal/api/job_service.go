@@ -2,6 +2,8 @@ package api
 
 import (
 	"errors"
+	"io"
+	"time"
 
 	"github.com/tmnhat2001/worker-service/internal/worker"
 )
@@ -12,16 +14,32 @@ type jobService struct {
 	jobStore worker.JobStore
 }
 
-func newJobService() *jobService {
+func newJobService(jobStore worker.JobStore) *jobService {
 	return &jobService{
-		jobStore: &worker.MemoryJobStore{
-			Jobs: make(map[string]worker.Job),
-		},
+		jobStore: jobStore,
 	}
 }
 
 func (s jobService) startJob(config jobActionConfig) (worker.Job, error) {
-	job := worker.Job{Command: config.command, User: config.user.Username}
+	job := worker.Job{
+		Command:         config.command,
+		Args:            config.args,
+		ShellSplit:      config.shellSplit,
+		Env:             config.env,
+		CleanEnv:        config.cleanEnv,
+		WorkDir:         config.workDir,
+		User:            config.user.Username,
+		Credential:      config.user.Credential,
+		Labels:          config.labels,
+		Limits:          config.limits,
+		Isolation:       config.isolation,
+		SecurityProfile: config.securityProfile,
+		Timeout:         config.timeout,
+		OutputLimits:    config.outputLimits,
+		TTY:             config.tty,
+		Input:           config.input,
+		OpenStdin:       config.openStdin,
+	}
 	err := (&job).Start(s.jobStore)
 	return job, err
 }
@@ -32,7 +50,8 @@ func (s jobService) stopJob(config jobActionConfig) (worker.Job, error) {
 		return job, err
 	}
 
-	err = job.Stop(s.jobStore)
+	options := worker.StopOptions{GracePeriod: config.gracePeriod, Force: config.force}
+	err = job.Stop(s.jobStore, options)
 	if err != nil {
 		return job, err
 	}
@@ -45,6 +64,80 @@ func (s jobService) stopJob(config jobActionConfig) (worker.Job, error) {
 	return updatedJob, nil
 }
 
+// pauseJob pauses a running job, see worker.Job.Pause
+func (s jobService) pauseJob(config jobActionConfig) (worker.Job, error) {
+	job, err := s.getJob(config)
+	if err != nil {
+		return job, err
+	}
+
+	err = job.Pause(s.jobStore)
+	if err != nil {
+		return job, err
+	}
+
+	return s.jobStore.FindJob(config.jobID)
+}
+
+// resumeJob resumes a paused job, see worker.Job.Resume
+func (s jobService) resumeJob(config jobActionConfig) (worker.Job, error) {
+	job, err := s.getJob(config)
+	if err != nil {
+		return job, err
+	}
+
+	err = job.Resume(s.jobStore)
+	if err != nil {
+		return job, err
+	}
+
+	return s.jobStore.FindJob(config.jobID)
+}
+
+// signalJob sends a signal to a running job, see worker.Job.SendSignal
+func (s jobService) signalJob(config jobActionConfig) (worker.Job, error) {
+	job, err := s.getJob(config)
+	if err != nil {
+		return job, err
+	}
+
+	err = job.SendSignal(s.jobStore, config.signal)
+	if err != nil {
+		return job, err
+	}
+
+	return s.jobStore.FindJob(config.jobID)
+}
+
+// writeStdin copies r to the stdin of the job, see worker.Job.WriteStdin
+func (s jobService) writeStdin(config jobActionConfig, r io.Reader, closeStdin bool) (int64, error) {
+	job, err := s.getJob(config)
+	if err != nil {
+		return 0, err
+	}
+
+	return job.WriteStdin(r, closeStdin)
+}
+
+// getJobDetails returns the job with the end of its output, see worker.FindJobDetails
+func (s jobService) getJobDetails(config jobActionConfig) (worker.Job, error) {
+	_, err := s.getJob(config)
+	if err != nil {
+		return worker.Job{}, err
+	}
+
+	return worker.FindJobDetails(s.jobStore, config.jobID)
+}
+
+func (s jobService) findOutput(config jobActionConfig) (*worker.JobOutput, error) {
+	_, err := s.getJob(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.jobStore.FindOutput(config.jobID)
+}
+
 func (s jobService) getJob(config jobActionConfig) (worker.Job, error) {
 	job, err := s.jobStore.FindJob(config.jobID)
 	if err != nil {
@@ -58,8 +151,31 @@ func (s jobService) getJob(config jobActionConfig) (worker.Job, error) {
 	return job, nil
 }
 
+// listJobs returns a page of the user's jobs matching the query
+func (s jobService) listJobs(user *User, query worker.JobQuery) (worker.JobPage, error) {
+	query.User = user.Username
+	return s.jobStore.ListJobs(query)
+}
+
 type jobActionConfig struct {
-	command string
-	user    *User
-	jobID   string
+	command         string
+	args            []string
+	shellSplit      bool
+	env             []string
+	cleanEnv        bool
+	workDir         string
+	gracePeriod     time.Duration
+	force           bool
+	user            *User
+	jobID           string
+	labels          map[string]string
+	limits          worker.ResourceLimits
+	isolation       string
+	securityProfile string
+	timeout         worker.Duration
+	outputLimits    worker.OutputLimits
+	tty             bool
+	input           io.Reader
+	openStdin       bool
+	signal          string
 }
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tmnhat2001/worker-service/internal/worker"
+)
+
+// signalJobRequest is the body of a request to the /signal endpoint.
+// Signal is the name of a signal of worker.AllowedSignals, e.g. "SIGHUP" or "HUP".
+type signalJobRequest struct {
+	ID     string
+	Signal string
+}
+
+// signalJob sends a signal to the processes of a running job. The job keeps its status, unless its process
+// exits because of the signal.
+func (server *Server) signalJob(req *http.Request) (interface{}, requestError) {
+	user, err := userFromContext(req.Context())
+	if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError}
+	}
+
+	var jobRequest signalJobRequest
+	err = json.NewDecoder(req.Body).Decode(&jobRequest)
+	if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to parse request", statusCode: http.StatusBadRequest}
+	}
+
+	config := jobActionConfig{user: user, jobID: jobRequest.ID, signal: jobRequest.Signal}
+	job, err := server.jobService.signalJob(config)
+	if (err == errUnauthorizedUser) || (err == worker.ErrJobNotFound) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to find job", statusCode: http.StatusNotFound}
+	} else if errors.Is(err, worker.ErrInvalidSignal) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Invalid signal. The allowed signals are " + strings.Join(worker.AllowedSignalNames(), ", "), statusCode: http.StatusBadRequest}
+	} else if errors.Is(err, worker.ErrJobNotRunning) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to signal job. The job has already finished.", statusCode: http.StatusConflict}
+	} else if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to signal job", statusCode: http.StatusInternalServerError}
+	}
+
+	return job, requestError{}
+}
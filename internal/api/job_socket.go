@@ -0,0 +1,316 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/tmnhat2001/worker-service/internal/worker"
+)
+
+// The following constants are possible values for the Type of a socketFrame
+const (
+	frameStdout = "stdout"
+	frameStderr = "stderr"
+	frameStatus = "status"
+	frameExit   = "exit"
+)
+
+const (
+	// socketWriteTimeout is how long the client has to receive a frame
+	socketWriteTimeout = 10 * time.Second
+	// socketPingInterval is how often the client is pinged, and socketPongTimeout how long it has to answer
+	socketPingInterval = 30 * time.Second
+	socketPongTimeout  = 60 * time.Second
+)
+
+// socketFrame is a JSON message sent on the WebSocket of a job. Stdout and stderr frames contain
+// output in Data, in which invalid UTF-8 is replaced. Status frames are sent when the job changes status,
+// starting with its current status. The exit frame is sent after all the output, once the job has its
+// final status, with the fields of the job describing how its process ended.
+type socketFrame struct {
+	Type         string
+	Data         string            `json:",omitempty"`
+	Status       worker.JobState   `json:",omitempty"`
+	StatusReason string            `json:",omitempty"`
+	ExitReason   worker.ExitReason `json:",omitempty"`
+	ExitCode     *int              `json:",omitempty"`
+	Signal       string            `json:",omitempty"`
+	CoreDumped   bool              `json:",omitempty"`
+}
+
+// jobSocket is the WebSocket connection of a client following a job. Frames are sent by several goroutines.
+type jobSocket struct {
+	conn  *websocket.Conn
+	mutex sync.Mutex
+}
+
+// send writes a frame to the client
+func (socket *jobSocket) send(frame socketFrame) error {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+
+	socket.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return socket.conn.WriteJSON(frame)
+}
+
+// sendBinary writes a binary message to the client
+func (socket *jobSocket) sendBinary(p []byte) error {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+
+	socket.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return socket.conn.WriteMessage(websocket.BinaryMessage, p)
+}
+
+// ping checks that the client is still connected
+func (socket *jobSocket) ping() error {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+
+	return socket.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout))
+}
+
+// close sends a close frame to the client
+func (socket *jobSocket) close() error {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "The job has finished")
+	return socket.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(socketWriteTimeout))
+}
+
+// read reads the messages sent by the client, which answers pings and closes the connection,
+// until the connection fails. The data messages are passed to handle, unless it is nil.
+func (socket *jobSocket) read(cancel context.CancelFunc, handle func(messageType int, p []byte)) {
+	defer cancel()
+
+	socket.conn.SetReadDeadline(time.Now().Add(socketPongTimeout))
+	socket.conn.SetPongHandler(func(string) error {
+		return socket.conn.SetReadDeadline(time.Now().Add(socketPongTimeout))
+	})
+
+	for {
+		messageType, p, err := socket.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if handle != nil {
+			handle(messageType, p)
+		}
+	}
+}
+
+// keepAlive pings the client until ctx is done. The connection is closed if a ping cannot be sent.
+func (socket *jobSocket) keepAlive(ctx context.Context) {
+	pingTicker := time.NewTicker(socketPingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case <-pingTicker.C:
+			err := socket.ping()
+			if err != nil {
+				socket.conn.Close()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// frameWriter sends the output written to it as frames of the given type
+type frameWriter struct {
+	socket    *jobSocket
+	frameType string
+}
+
+func (writer frameWriter) Write(p []byte) (int, error) {
+	err := writer.socket.send(socketFrame{Type: writer.frameType, Data: string(p)})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// checkOrigin allows the WebSockets opened by the server's own pages, by clients which are not browsers,
+// and by the pages of the WebSocketOrigins
+func (server *Server) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	if err == nil && originURL.Host == req.Host {
+		return true
+	}
+
+	for _, allowed := range server.config.WebSocketOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jobSocket upgrades the request to a WebSocket on which the output, the status changes and the exit
+// of the job are sent as socketFrames. The socket is closed once the job has finished.
+func (server *Server) jobSocket(w http.ResponseWriter, req *http.Request) {
+	user, err := userFromContext(req.Context())
+	if err != nil {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError})
+		return
+	}
+
+	requestVars := mux.Vars(req)
+	config := jobActionConfig{user: user, jobID: requestVars["jobID"]}
+	output, err := server.jobService.findOutput(config)
+	if (err == errUnauthorizedUser) || (err == worker.ErrJobNotFound) {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "Failed to find job", statusCode: http.StatusNotFound})
+		return
+	} else if err != nil {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "An unexpected error has occurred", statusCode: http.StatusInternalServerError})
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: server.checkOrigin}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// The upgrader has already returned the error to the client
+		server.logger.WithFields(logrus.Fields{"endpoint": req.URL.Path}).Warn(err)
+		return
+	}
+	defer conn.Close()
+
+	socket := &jobSocket{conn: conn}
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	go socket.read(cancel, nil)
+	go socket.keepAlive(ctx)
+
+	var wg sync.WaitGroup
+	for stream, frameType := range map[string]string{worker.StreamStdout: frameStdout, worker.StreamStderr: frameStderr} {
+		wg.Add(1)
+		go func(stream, frameType string) {
+			defer wg.Done()
+			output.Stream(ctx, frameWriter{socket: socket, frameType: frameType}, stream, true)
+		}(stream, frameType)
+	}
+
+	err = server.sendJobStatus(ctx, socket, config.jobID)
+	if err != nil {
+		cancel()
+		wg.Wait()
+		if ctx.Err() == nil {
+			server.logger.WithFields(logrus.Fields{"endpoint": req.URL.Path}).Error(err)
+		}
+
+		return
+	}
+
+	// The exit frame is sent after all the output
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	err = server.sendJobExit(socket, config.jobID)
+	if err != nil {
+		server.logger.WithFields(logrus.Fields{"endpoint": req.URL.Path}).Error(err)
+	}
+}
+
+// sendJobExit sends the exit frame of a finished job, then closes the socket
+func (server *Server) sendJobExit(socket *jobSocket, jobID string) error {
+	job, err := server.jobService.jobStore.FindJob(jobID)
+	if err != nil {
+		return err
+	}
+
+	err = socket.send(socketFrame{
+		Type:         frameExit,
+		Status:       job.Status,
+		StatusReason: job.StatusReason,
+		ExitReason:   job.ExitReason,
+		ExitCode:     job.ExitCode,
+		Signal:       job.Signal,
+		CoreDumped:   job.CoreDumped,
+	})
+	if err != nil {
+		return err
+	}
+
+	return socket.close()
+}
+
+// sendJobStatus sends a status frame with the current status of the job, then each time it changes,
+// until it has a final status
+func (server *Server) sendJobStatus(ctx context.Context, socket *jobSocket, jobID string) error {
+	return server.followJobStatus(ctx, jobID, func(status worker.JobState, reason string) error {
+		return socket.send(socketFrame{Type: frameStatus, Status: status, StatusReason: reason})
+	})
+}
+
+// followJobStatus calls changed with the current status of the job, then each time it changes,
+// until it has a final status or ctx is done
+func (server *Server) followJobStatus(ctx context.Context, jobID string, changed func(status worker.JobState, reason string) error) error {
+	var status worker.JobState
+	var subscription *worker.Subscription
+	defer func() {
+		if subscription != nil {
+			subscription.Close()
+		}
+	}()
+
+	for {
+		// Subscribes before reading the job, so that no change is missed. The job is read again if
+		// the subscription has been dropped.
+		if subscription == nil {
+			subscription, _, _ = server.jobService.jobStore.Events().Subscribe("", 0)
+			job, err := server.jobService.jobStore.FindJob(jobID)
+			if err != nil {
+				return err
+			}
+
+			if job.Status != status {
+				status = job.Status
+				err = changed(job.Status, job.StatusReason)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if status.Final() {
+			return nil
+		}
+
+		select {
+		case event, ok := <-subscription.Events:
+			if !ok {
+				subscription = nil
+				continue
+			}
+
+			if event.JobID == jobID && event.Status != status {
+				status = event.Status
+				err := changed(event.Status, event.StatusReason)
+				if err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
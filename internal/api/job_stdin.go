@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/tmnhat2001/worker-service/internal/worker"
+)
+
+// DefaultMaxStdinBytes is the largest stdin sent with a /start request, if the ServerConfig does not set MaxStdinBytes
+const DefaultMaxStdinBytes = 64 << 20
+
+// multipartMemory is how much of a multipart /start request is kept in memory. The rest of the stdin
+// is written to a temporary file.
+const multipartMemory = 1 << 20
+
+// errStdinTooLarge is returned when reading a /start request whose stdin is larger than MaxStdinBytes
+var errStdinTooLarge = errors.New("The stdin of the job is too large")
+
+// startJobRequest is the body of a request to the /start endpoint: a Job, and the input of its process
+// in Stdin. A multipart/form-data request contains the job as JSON in its "job" field, and the input
+// in its "stdin" file.
+type startJobRequest struct {
+	worker.Job
+	Stdin     string
+	stdinFile *multipart.FileHeader
+}
+
+// decodeStartRequest reads the body of a /start request, which is limited to maxStdinBytes of stdin
+// on top of the job itself
+func decodeStartRequest(req *http.Request, maxStdinBytes int64) (startJobRequest, error) {
+	var request startJobRequest
+	req.Body = ioutil.NopCloser(&limitedReader{r: req.Body, remaining: maxStdinBytes + multipartMemory})
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		err := json.NewDecoder(req.Body).Decode(&request)
+		if err == nil && int64(len(request.Stdin)) > maxStdinBytes {
+			err = errStdinTooLarge
+		}
+
+		return request, err
+	}
+
+	err := req.ParseMultipartForm(multipartMemory)
+	if err != nil {
+		return request, err
+	}
+
+	err = json.Unmarshal([]byte(req.FormValue("job")), &request.Job)
+	if err != nil {
+		return request, err
+	}
+
+	files := req.MultipartForm.File["stdin"]
+	if len(files) > 0 {
+		request.stdinFile = files[0]
+		if request.stdinFile.Size > maxStdinBytes {
+			return request, errStdinTooLarge
+		}
+	}
+
+	return request, nil
+}
+
+// input returns the reader of the stdin sent with the request, or nil if there is none.
+// The uploaded file is kept open until the job's process has exited, even once the server has removed it.
+func (request startJobRequest) input() (io.Reader, error) {
+	if request.stdinFile != nil {
+		return request.stdinFile.Open()
+	}
+
+	if request.Stdin != "" {
+		return strings.NewReader(request.Stdin), nil
+	}
+
+	return nil, nil
+}
+
+// limitedReader fails with errStdinTooLarge once more than remaining bytes are read
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (reader *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > reader.remaining+1 {
+		p = p[:reader.remaining+1]
+	}
+
+	n, err := reader.r.Read(p)
+	if int64(n) > reader.remaining {
+		n = int(reader.remaining)
+		reader.remaining = 0
+		return n, errStdinTooLarge
+	}
+
+	reader.remaining -= int64(n)
+	return n, err
+}
+
+// stdinResponse is the response of the /jobs/{id}/stdin endpoint
+type stdinResponse struct {
+	BytesWritten int64
+	Closed       bool
+}
+
+// writeStdin copies the body of the request, which can be streamed, to the stdin of a job started with
+// OpenStdin. The stdin is closed at the end of the body, which sends EOF to the job, unless the "close"
+// parameter is false.
+func (server *Server) writeStdin(req *http.Request) (interface{}, requestError) {
+	user, err := userFromContext(req.Context())
+	if err != nil {
+		return stdinResponse{}, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError}
+	}
+
+	closeStdin := true
+	if value := req.URL.Query().Get("close"); value != "" {
+		closeStdin, err = strconv.ParseBool(value)
+		if err != nil {
+			return stdinResponse{}, requestError{wrappedError: err, message: "Invalid close parameter", statusCode: http.StatusBadRequest}
+		}
+	}
+
+	requestVars := mux.Vars(req)
+	config := jobActionConfig{user: user, jobID: requestVars["jobID"]}
+	written, err := server.jobService.writeStdin(config, req.Body, closeStdin)
+	if (err == errUnauthorizedUser) || (err == worker.ErrJobNotFound) {
+		return stdinResponse{}, requestError{wrappedError: err, message: "Failed to find job", statusCode: http.StatusNotFound}
+	} else if errors.Is(err, worker.ErrStdinClosed) {
+		return stdinResponse{}, requestError{wrappedError: err, message: "The job's stdin is closed", statusCode: http.StatusConflict}
+	} else if errors.Is(err, worker.ErrStdinBusy) {
+		return stdinResponse{}, requestError{wrappedError: err, message: "The job's stdin is already being written", statusCode: http.StatusConflict}
+	} else if errors.Is(err, worker.ErrJobNotRunning) {
+		return stdinResponse{}, requestError{wrappedError: err, message: "The job has already finished", statusCode: http.StatusConflict}
+	} else if err != nil {
+		return stdinResponse{}, requestError{wrappedError: err, message: "Failed to write to the job's stdin", statusCode: http.StatusInternalServerError}
+	}
+
+	return stdinResponse{BytesWritten: written, Closed: closeStdin}, requestError{}
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
@@ -13,7 +16,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-type customHandler func(r *http.Request) (worker.Job, requestError)
+type customHandler func(r *http.Request) (interface{}, requestError)
 
 // Server represents server that handles API requests
 type Server struct {
@@ -26,18 +29,32 @@ type Server struct {
 
 // NewServer returns a new Server instance
 func NewServer(config ServerConfig) (*Server, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+
 	authService, err := newAuthenticationService()
 	if err != nil {
 		return nil, err
 	}
 
+	jobStore, err := config.jobStore()
+	if err != nil {
+		return nil, err
+	}
+
 	server := &Server{
 		authService: authService,
-		jobService:  newJobService(),
+		jobService:  newJobService(jobStore),
 		logger:      logrus.New(),
 		config:      config,
 	}
 
+	if fileStore, ok := jobStore.(*worker.FileJobStore); ok {
+		server.recoverJobs(fileStore)
+	}
+
 	httpServer := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Port),
 		Handler: server.registerRoutes(),
@@ -47,6 +64,21 @@ func NewServer(config ServerConfig) (*Server, error) {
 	return server, nil
 }
 
+// recoverJobs reconciles the jobs that were running when the server stopped and logs the results
+func (server *Server) recoverJobs(store *worker.FileJobStore) {
+	recoveries := store.RecoverJobs()
+	for _, recovery := range recoveries {
+		fields := logrus.Fields{"job": recovery.JobID, "result": recovery.Result}
+		if recovery.Result == worker.RecoveryLost {
+			server.logger.WithFields(fields).Warn(recovery.Reason)
+		} else {
+			server.logger.WithFields(fields).Info("Re-adopted running job")
+		}
+	}
+
+	server.logger.Infof("Recovered %d running jobs", len(recoveries))
+}
+
 // Run starts the Server
 func (server *Server) Run() error {
 	return server.httpServer.ListenAndServeTLS(server.config.CertFilePath, server.config.KeyFilePath)
@@ -57,7 +89,16 @@ func (server *Server) registerRoutes() *mux.Router {
 
 	router.HandleFunc("/start", server.makeHandler(server.startJob)).Methods("POST")
 	router.Handle("/stop", server.makeHandler(server.stopJob)).Methods("PUT")
+	router.Handle("/pause", server.makeHandler(server.pauseJob)).Methods("PUT")
+	router.Handle("/resume", server.makeHandler(server.resumeJob)).Methods("PUT")
+	router.Handle("/signal", server.makeHandler(server.signalJob)).Methods("PUT")
+	router.Handle("/jobs", server.makeHandler(server.listJobs)).Methods("GET")
+	router.Handle("/events", server.authHandler(server.streamEvents)).Methods("GET")
 	router.Handle("/jobs/{jobID}", server.makeHandler(server.getJobResults)).Methods("GET")
+	router.Handle("/jobs/{jobID}/logs", server.authHandler(server.streamLogs)).Methods("GET")
+	router.Handle("/jobs/{jobID}/ws", server.authHandler(server.jobSocket)).Methods("GET")
+	router.Handle("/jobs/{jobID}/attach", server.authHandler(server.attachJob)).Methods("GET")
+	router.Handle("/jobs/{jobID}/stdin", server.makeHandler(server.writeStdin)).Methods("POST")
 
 	return router
 }
@@ -88,66 +129,151 @@ func (server *Server) authHandler(next http.HandlerFunc) http.HandlerFunc {
 
 func (server *Server) requestHandler(fn customHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		job, err := fn(req)
+		response, err := fn(req)
 		if (err != requestError{}) {
-			server.logger.WithFields(logrus.Fields{
-				"endpoint": req.URL.Path,
-			}).Error(errors.Unwrap(err))
-
-			errorResponse(w, err.message, err.statusCode)
+			server.requestFailed(w, req, err)
 			return
 		}
 
-		jsonResponse(w, job, http.StatusOK)
+		jsonResponse(w, response, http.StatusOK)
 	}
 }
 
+// requestFailed logs the error of a request and returns its message to the user
+func (server *Server) requestFailed(w http.ResponseWriter, req *http.Request, err requestError) {
+	server.logger.WithFields(logrus.Fields{
+		"endpoint": req.URL.Path,
+	}).Error(errors.Unwrap(err))
+
+	errorResponse(w, err.message, err.statusCode)
+}
+
 func (server *Server) close() {
 	err := server.httpServer.Close()
 	if err != nil {
 		server.logger.Error(err)
 	}
+
+	if closer, ok := server.jobService.jobStore.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			server.logger.Error(err)
+		}
+	}
 }
 
-func (server *Server) startJob(req *http.Request) (worker.Job, requestError) {
+func (server *Server) startJob(req *http.Request) (interface{}, requestError) {
 	user, err := userFromContext(req.Context())
 	if err != nil {
 		return worker.Job{}, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError}
 	}
 
-	var job worker.Job
-	decoder := json.NewDecoder(req.Body)
-	err = decoder.Decode(&job)
-	if err != nil {
+	request, err := decodeStartRequest(req, server.config.maxStdinBytes())
+	if errors.Is(err, errStdinTooLarge) {
+		return worker.Job{}, requestError{wrappedError: err, message: err.Error(), statusCode: http.StatusRequestEntityTooLarge}
+	} else if err != nil {
 		return worker.Job{}, requestError{wrappedError: err, message: "Failed to parse request", statusCode: http.StatusNotFound}
 	}
 
-	config := jobActionConfig{command: job.Command, user: user}
-	updatedJob, err := server.jobService.startJob(config)
+	job := request.Job
+	err = validateStartRequest(job)
+	if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: err.Error(), statusCode: http.StatusBadRequest}
+	}
+
+	timeout, err := jobTimeout(job.Timeout, server.config)
+	if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: err.Error(), statusCode: http.StatusBadRequest}
+	}
+
+	err = user.checkCredential()
+	if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: err.Error(), statusCode: http.StatusForbidden}
+	}
+
+	securityProfile, err := jobSecurityProfile(job.SecurityProfile, user)
 	if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: err.Error(), statusCode: http.StatusBadRequest}
+	}
+
+	input, err := request.input()
+	if err != nil {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to read the job's stdin", statusCode: http.StatusInternalServerError}
+	}
+
+	config := jobActionConfig{
+		command:         job.Command,
+		args:            job.Args,
+		shellSplit:      job.ShellSplit,
+		env:             job.Env,
+		cleanEnv:        job.CleanEnv,
+		workDir:         job.WorkDir,
+		user:            user,
+		limits:          job.Limits,
+		labels:          job.Labels,
+		isolation:       job.Isolation,
+		securityProfile: securityProfile,
+		timeout:         timeout,
+		outputLimits:    server.config.outputLimits(),
+		tty:             job.TTY,
+		input:           input,
+		openStdin:       job.OpenStdin,
+	}
+	updatedJob, err := server.jobService.startJob(config)
+	if errors.Is(err, worker.ErrInvalidCommand) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Invalid command", statusCode: http.StatusBadRequest}
+	} else if err == worker.ErrInvalidIsolation {
+		return worker.Job{}, requestError{wrappedError: err, message: "Invalid isolation mode", statusCode: http.StatusBadRequest}
+	} else if err == worker.ErrInvalidSecurityProfile {
+		return worker.Job{}, requestError{wrappedError: err, message: "Invalid security profile", statusCode: http.StatusBadRequest}
+	} else if errors.Is(err, worker.ErrInvalidWorkDir) {
+		return worker.Job{}, requestError{wrappedError: err, message: "The working directory does not exist or cannot be accessed by the job", statusCode: http.StatusBadRequest}
+	} else if errors.Is(err, worker.ErrInvalidStdin) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Invalid stdin", statusCode: http.StatusBadRequest}
+	} else if errors.Is(err, worker.ErrCgroupUnavailable) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Resource limits are not supported by the server", statusCode: http.StatusBadRequest}
+	} else if err != nil {
 		return worker.Job{}, requestError{wrappedError: err, message: "Failed to start job", statusCode: http.StatusInternalServerError}
 	}
 
 	return updatedJob, requestError{}
 }
 
-func (server *Server) stopJob(req *http.Request) (worker.Job, requestError) {
+// stopJobRequest is the body of a request to the /stop endpoint.
+// GracePeriod is a duration such as "30s". It defaults to worker.DefaultGracePeriod.
+type stopJobRequest struct {
+	ID          string
+	GracePeriod string
+	Force       bool
+}
+
+func (server *Server) stopJob(req *http.Request) (interface{}, requestError) {
 	user, err := userFromContext(req.Context())
 	if err != nil {
 		return worker.Job{}, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError}
 	}
 
-	var jobRequest worker.Job
+	var jobRequest stopJobRequest
 	decoder := json.NewDecoder(req.Body)
 	err = decoder.Decode(&jobRequest)
 	if err != nil {
 		return worker.Job{}, requestError{wrappedError: err, message: "Failed to parse request", statusCode: http.StatusNotFound}
 	}
 
-	config := jobActionConfig{user: user, jobID: jobRequest.ID}
+	gracePeriod := worker.DefaultGracePeriod
+	if jobRequest.GracePeriod != "" {
+		gracePeriod, err = time.ParseDuration(jobRequest.GracePeriod)
+		if err != nil || gracePeriod < 0 {
+			return worker.Job{}, requestError{wrappedError: err, message: "Invalid grace period", statusCode: http.StatusBadRequest}
+		}
+	}
+
+	config := jobActionConfig{user: user, jobID: jobRequest.ID, gracePeriod: gracePeriod, force: jobRequest.Force}
 	job, err := server.jobService.stopJob(config)
 	if (err == errUnauthorizedUser) || (err == worker.ErrJobNotFound) {
 		return worker.Job{}, requestError{wrappedError: err, message: "Failed to find job", statusCode: http.StatusNotFound}
+	} else if errors.Is(err, worker.ErrJobNotRunning) {
+		return worker.Job{}, requestError{wrappedError: err, message: "Failed to stop job. The job has already finished.", statusCode: http.StatusConflict}
 	} else if err != nil {
 		return worker.Job{}, requestError{wrappedError: err, message: "Failed to stop job. The job may have already finished.", statusCode: http.StatusInternalServerError}
 	}
@@ -155,7 +281,7 @@ func (server *Server) stopJob(req *http.Request) (worker.Job, requestError) {
 	return job, requestError{}
 }
 
-func (server *Server) getJobResults(req *http.Request) (worker.Job, requestError) {
+func (server *Server) getJobResults(req *http.Request) (interface{}, requestError) {
 	user, err := userFromContext(req.Context())
 	if err != nil {
 		return worker.Job{}, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError}
@@ -163,7 +289,7 @@ func (server *Server) getJobResults(req *http.Request) (worker.Job, requestError
 
 	requestVars := mux.Vars(req)
 	config := jobActionConfig{user: user, jobID: requestVars["jobID"]}
-	job, err := server.jobService.getJob(config)
+	job, err := server.jobService.getJobDetails(config)
 	if (err == errUnauthorizedUser) || (err == worker.ErrJobNotFound) {
 		return worker.Job{}, requestError{wrappedError: err, message: "Failed to find job", statusCode: http.StatusNotFound}
 	} else if err != nil {
@@ -172,3 +298,77 @@ func (server *Server) getJobResults(req *http.Request) (worker.Job, requestError
 
 	return job, requestError{}
 }
+
+func (server *Server) listJobs(req *http.Request) (interface{}, requestError) {
+	user, err := userFromContext(req.Context())
+	if err != nil {
+		return worker.JobPage{}, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError}
+	}
+
+	query, err := parseJobQuery(req.URL.Query())
+	if err != nil {
+		return worker.JobPage{}, requestError{wrappedError: err, message: err.Error(), statusCode: http.StatusBadRequest}
+	}
+
+	page, err := server.jobService.listJobs(user, query)
+	if errors.Is(err, worker.ErrInvalidCursor) {
+		return worker.JobPage{}, requestError{wrappedError: err, message: "Invalid cursor", statusCode: http.StatusBadRequest}
+	} else if err != nil {
+		return worker.JobPage{}, requestError{wrappedError: err, message: "An unexpected error has occurred", statusCode: http.StatusInternalServerError}
+	}
+
+	return page, requestError{}
+}
+
+// streamLogs writes the output of a job in a chunked response. "stream" is "stdout", "stderr" or "both"
+// (the default), where both streams are interleaved. If "follow" is true, the new output is written as
+// the job writes it, and the response ends once the job has finished. Otherwise, it ends after the
+// current output.
+func (server *Server) streamLogs(w http.ResponseWriter, req *http.Request) {
+	user, err := userFromContext(req.Context())
+	if err != nil {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "Internal server error", statusCode: http.StatusInternalServerError})
+		return
+	}
+
+	values := req.URL.Query()
+	stream := values.Get("stream")
+	switch stream {
+	case "":
+		stream = worker.StreamBoth
+	case worker.StreamStdout, worker.StreamStderr, worker.StreamBoth:
+	default:
+		server.requestFailed(w, req, requestError{wrappedError: worker.ErrInvalidStream, message: `The stream must be "stdout", "stderr" or "both"`, statusCode: http.StatusBadRequest})
+		return
+	}
+
+	follow := false
+	if value := values.Get("follow"); value != "" {
+		follow, err = strconv.ParseBool(value)
+		if err != nil {
+			server.requestFailed(w, req, requestError{wrappedError: err, message: "follow must be true or false", statusCode: http.StatusBadRequest})
+			return
+		}
+	}
+
+	requestVars := mux.Vars(req)
+	config := jobActionConfig{user: user, jobID: requestVars["jobID"]}
+	output, err := server.jobService.findOutput(config)
+	if (err == errUnauthorizedUser) || (err == worker.ErrJobNotFound) {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "Failed to find job", statusCode: http.StatusNotFound})
+		return
+	} else if err != nil {
+		server.requestFailed(w, req, requestError{wrappedError: err, message: "An unexpected error has occurred", statusCode: http.StatusInternalServerError})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	// The error cannot be returned to the user once the response has started
+	err = output.Stream(req.Context(), flushWriter{w}, stream, follow)
+	if err != nil && req.Context().Err() == nil {
+		server.logger.WithFields(logrus.Fields{"endpoint": req.URL.Path}).Error(err)
+	}
+}
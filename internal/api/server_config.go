@@ -1,8 +1,119 @@
 package api
 
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tmnhat2001/worker-service/internal/worker"
+)
+
 // ServerConfig contains the configurations for a Server
 type ServerConfig struct {
 	Port         int
 	CertFilePath string
 	KeyFilePath  string
+	// DefaultJobTimeout is the timeout of the jobs started without one, and MaxJobTimeout is the longest
+	// timeout a job can have. Zero values mean no time limit.
+	DefaultJobTimeout time.Duration
+	MaxJobTimeout     time.Duration
+	// DataDir contains the output past the memory limit, and the jobs of the file store. It defaults to a
+	// directory in os.TempDir().
+	DataDir string
+	// The output limits of each job are described in worker.OutputLimits
+	OutputMemoryLimit int64
+	OutputHardLimit   int64
+	OutputLimitPolicy string
+	// JobStore is JobStoreMemory (the default) or JobStoreFile. The file store records the jobs and their
+	// whole output under DataDir, so that they are kept when the server restarts.
+	JobStore string
+	// WebSocketOrigins are the origins of the pages allowed to open the WebSocket of a job, such as
+	// "https://tools.example.com". Pages served by the API server itself are always allowed.
+	WebSocketOrigins []string
+	// MaxStdinBytes is the largest stdin that can be sent with a /start request. It defaults to DefaultMaxStdinBytes.
+	MaxStdinBytes int64
+}
+
+// The following constants are possible values for the JobStore of a ServerConfig
+const (
+	JobStoreMemory = "memory"
+	JobStoreFile   = "file"
+)
+
+func (config ServerConfig) validate() error {
+	switch config.OutputLimitPolicy {
+	case "", worker.OutputPolicyTruncate, worker.OutputPolicyKill:
+	default:
+		return errors.Errorf("Invalid output limit policy: %s", config.OutputLimitPolicy)
+	}
+
+	switch config.JobStore {
+	case "", JobStoreMemory, JobStoreFile:
+		return nil
+	default:
+		return errors.Errorf("Invalid job store: %s", config.JobStore)
+	}
+}
+
+func (config ServerConfig) dataDir() string {
+	if config.DataDir == "" {
+		return filepath.Join(os.TempDir(), "worker-service")
+	}
+
+	return config.DataDir
+}
+
+func (config ServerConfig) maxStdinBytes() int64 {
+	if config.MaxStdinBytes <= 0 {
+		return DefaultMaxStdinBytes
+	}
+
+	return config.MaxStdinBytes
+}
+
+func (config ServerConfig) outputLimits() worker.OutputLimits {
+	policy := config.OutputLimitPolicy
+	if policy == "" {
+		policy = worker.OutputPolicyTruncate
+	}
+
+	return worker.OutputLimits{
+		MemoryLimit: config.OutputMemoryLimit,
+		HardLimit:   config.OutputHardLimit,
+		Policy:      policy,
+		Dir:         filepath.Join(config.dataDir(), "output"),
+		Persist:     config.JobStore == JobStoreFile,
+	}
+}
+
+func (config ServerConfig) jobStore() (worker.JobStore, error) {
+	if config.JobStore == JobStoreFile {
+		return worker.OpenFileJobStore(filepath.Join(config.dataDir(), "store"))
+	}
+
+	err := config.removeSpilledOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	return worker.NewMemoryJobStore(), nil
+}
+
+// removeSpilledOutput removes the output files left by a previous server which kept its jobs in memory.
+// They are kept if a file store has been used in DataDir, since its jobs refer to them.
+func (config ServerConfig) removeSpilledOutput() error {
+	_, err := os.Stat(filepath.Join(config.dataDir(), "store"))
+	if err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "Unable to check for a file job store")
+	}
+
+	err = os.RemoveAll(config.outputLimits().Dir)
+	if err != nil {
+		return errors.Wrap(err, "Unable to remove spilled output")
+	}
+
+	return nil
 }
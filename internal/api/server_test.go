@@ -1,14 +1,24 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"github.com/tmnhat2001/worker-service/internal/worker"
 )
@@ -25,6 +35,7 @@ func TestStartJob(t *testing.T) {
 
 	go server.Run()
 	defer server.close()
+	waitForServer(8989)
 
 	command := "echo \"hello world\""
 	response, err := executeStartJobRequest(command, "user1", "thisispasswordforuser1")
@@ -57,8 +68,8 @@ Expected: %s
 Got: %s`, command, job.Command)
 	}
 
-	if job.ExitCode != "" {
-		t.Errorf("Expected the job exit code to be empty, but got %s", job.ExitCode)
+	if job.ExitCode != nil || job.ExitReason != "" {
+		t.Errorf("Expected the job not to have exited, but got exit code %v, reason '%s'", job.ExitCode, job.ExitReason)
 	}
 }
 
@@ -71,6 +82,7 @@ func TestStopJob(t *testing.T) {
 
 	go server.Run()
 	defer server.close()
+	waitForServer(8989)
 
 	username := "user1"
 	password := "thisispasswordforuser1"
@@ -103,16 +115,1705 @@ func TestStopJob(t *testing.T) {
 		t.Error(err)
 	}
 
-	if job2.Status != worker.Stopped {
-		t.Errorf("Expected the job status to be '%s', but got '%s'", worker.Stopped, job2.Status)
+	if job2.Status != worker.Stopping && job2.Status != worker.Stopped {
+		t.Errorf("Expected the job status to be '%s', but got '%s'", worker.Stopping, job2.Status)
 	}
 
-	if job2.ExitCode != "-1" {
-		t.Errorf("Expected the job exit code to be -1, but got %s", job2.ExitCode)
+	job3, err := waitForJobStatus(job1.ID, worker.Stopped, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job3.ExitCode != nil || job3.ExitReason != worker.ExitReasonSignaled {
+		t.Errorf("Expected the job to be signaled without an exit code, but got exit code %v, reason '%s'", job3.ExitCode, job3.ExitReason)
+	}
+
+	if job3.Signal != "SIGTERM" {
+		t.Errorf("Expected the job to be terminated by SIGTERM, but got '%s'", job3.Signal)
+	}
+
+	stopResponse, err = executeStopJobRequest(job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stopResponse.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status code 409 when stopping a stopped job, but got %d", stopResponse.StatusCode)
+	}
+}
+
+func TestStopJobGracePeriod(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	tests := []struct {
+		stopBody       map[string]interface{}
+		expectStopping bool
+	}{
+		{map[string]interface{}{"GracePeriod": "2s"}, true},
+		{map[string]interface{}{"GracePeriod": "1h", "Force": true}, false},
+	}
+
+	for _, test := range tests {
+		// The ignored SIGTERM is inherited by sleep
+		body := map[string]interface{}{"Args": []string{"sh", "-c", "trap '' TERM; sleep 30"}}
+		startResponse, err := executeStartRequest(body, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job1, err := getJobFromResponse(startResponse)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		test.stopBody["ID"] = job1.ID
+		stopResponse, err := executeStopRequest(test.stopBody, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if stopResponse.StatusCode != http.StatusOK {
+			t.Errorf("Expected status code 200, but got %d", stopResponse.StatusCode)
+		}
+
+		if test.expectStopping {
+			job2, err := waitForJobStatus(job1.ID, worker.Stopping, username, password)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if job2.Signal != "" {
+				t.Errorf("Expected the job to still be running, but it was terminated by %s", job2.Signal)
+			}
+		}
+
+		job3, err := waitForJobStatus(job1.ID, worker.Stopped, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if job3.Signal != "SIGKILL" {
+			t.Errorf("Expected the job to be terminated by SIGKILL, but got '%s'", job3.Signal)
+		}
+	}
+}
+
+func TestStopJobProcessTree(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	body := map[string]interface{}{"Args": []string{"sh", "-c", "sleep 987 & sleep 986"}}
+	startResponse, err := executeStartRequest(body, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if !processRunning("sleep\x00987") {
+		t.Error("Expected the child process of the job to be running")
+	}
+
+	_, err = executeStopJobRequest(job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = waitForJobStatus(job1.ID, worker.Stopped, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if processRunning("sleep\x00987") || processRunning("sleep\x00986") {
+		t.Error("Expected all the processes of the job to be stopped")
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	config := testServerConfig(8989)
+	config.DefaultJobTimeout = 500 * time.Millisecond
+	config.MaxJobTimeout = time.Hour
+	server, err := NewServer(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	startResponse, err := executeStartJobRequest("sleep 30", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job1.Timeout != worker.Duration(500*time.Millisecond) {
+		t.Errorf("Expected the job to have the default timeout, but got %s", job1.Timeout)
+	}
+
+	job2, err := waitForJobStatus(job1.ID, worker.TimedOut, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job2.Signal != "SIGTERM" {
+		t.Errorf("Expected the job to be terminated by SIGTERM, but got '%s'", job2.Signal)
+	}
+
+	if job2.ExitReason != worker.ExitReasonTimedOut {
+		t.Errorf("Expected the exit reason to be '%s', but got '%s'", worker.ExitReasonTimedOut, job2.ExitReason)
+	}
+
+	body := map[string]interface{}{"Command": "sleep 30", "Timeout": "2h"}
+	response, err := executeStartRequest(body, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, but got %d", response.StatusCode)
+	}
+
+	expectErrorMessage(response, "The timeout cannot be longer than 1h0m0s", t)
+}
+
+func TestPauseResumeJob(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	startResponse, err := executeStartJobRequest("sleep 30", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err := executePauseRequest("pause", job1.ID, "user2", "thisispasswordforuser2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404 when pausing the job of another user, but got %d", response.StatusCode)
+	}
+
+	response, err = executePauseRequest("pause", job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job2, err := getJobFromResponse(response)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job2.Status != worker.Paused || job2.PausedAt == nil {
+		t.Errorf("Expected the job status to be '%s', but got '%s'", worker.Paused, job2.Status)
+	}
+
+	response, err = executePauseRequest("pause", job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status code 409 when pausing a paused job, but got %d", response.StatusCode)
+	}
+
+	expectErrorMessage(response, "Failed to pause job. The job is already paused.", t)
+
+	time.Sleep(200 * time.Millisecond)
+	response, err = executePauseRequest("resume", job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job3, err := getJobFromResponse(response)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job3.Status != worker.Running || job3.PausedDuration < worker.Duration(200*time.Millisecond) {
+		t.Errorf("Expected the job to be running after a pause of at least 200ms, but got '%s' after %s", job3.Status, job3.PausedDuration)
+	}
+
+	response, err = executePauseRequest("resume", job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status code 409 when resuming a running job, but got %d", response.StatusCode)
+	}
+
+	expectErrorMessage(response, "Failed to resume job. The job is not paused.", t)
+
+	_, err = executeStopJobRequest(job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = waitForJobStatus(job1.ID, worker.Stopped, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err = executePauseRequest("pause", job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status code 409 when pausing a stopped job, but got %d", response.StatusCode)
+	}
+}
+
+func TestSignalJob(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	eventsResponse, err := executeEventsRequest("", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer eventsResponse.Body.Close()
+	events := bufio.NewReader(eventsResponse.Body)
+
+	body := map[string]interface{}{"Args": []string{"sh", "-c", "trap 'echo dumped' USR1; echo ready; while true; do sleep 0.05; done"}}
+	startResponse, err := executeStartRequest(body, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The signal would terminate the shell before its trap is set
+	_, err = waitForJobOutput(job1.ID, "ready", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err := executeSignalRequest(job1.ID, "USR1", "user2", "thisispasswordforuser2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404 when signaling the job of another user, but got %d", response.StatusCode)
+	}
+
+	response, err = executeSignalRequest(job1.ID, "KILL", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 when sending SIGKILL, but got %d", response.StatusCode)
+	}
+
+	expectErrorMessage(response, "Invalid signal. The allowed signals are SIGHUP, SIGINT, SIGQUIT, SIGTERM, SIGUSR1, SIGUSR2, SIGALRM, SIGWINCH", t)
+
+	response, err = executeSignalRequest(job1.ID, "USR1", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job2, err := getJobFromResponse(response)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job2.Status != worker.Running {
+		t.Errorf("Expected the job to keep running, but got '%s'", job2.Status)
+	}
+
+	_, err = waitForJobOutput(job1.ID, "dumped", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []string{worker.EventJobCreated, worker.EventJobStatusChanged, worker.EventJobSignaled}
+	for _, expectedType := range expected {
+		_, eventType, event, err := readServerEvent(events)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if eventType != expectedType || event.JobID != job1.ID {
+			t.Errorf("Expected a %s event of job %s, but got %s %+v", expectedType, job1.ID, eventType, event)
+		}
+
+		if eventType == worker.EventJobSignaled && (event.Signal != "SIGUSR1" || event.Status != worker.Running) {
+			t.Errorf("Expected the event of SIGUSR1 sent to the running job, but got %+v", event)
+		}
+	}
+
+	response, err = executeSignalRequest(job1.ID, "SIGTERM", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	response.Body.Close()
+
+	job3, err := waitForJobStatus(job1.ID, worker.Errored, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job3.Signal != "SIGTERM" {
+		t.Errorf("Expected the job to be terminated by SIGTERM, but got '%s'", job3.Signal)
+	}
+
+	response, err = executeSignalRequest(job1.ID, "HUP", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status code 409 when signaling a finished job, but got %d", response.StatusCode)
+	}
+}
+
+func TestJobCredential(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	users := map[string]string{"user1": "1001", "user2": "1002"}
+	for username, id := range users {
+		password := "thisispasswordfor" + username
+
+		// The account has no supplementary groups, and cannot write to the server's files
+		body := map[string]interface{}{"Args": []string{"sh", "-c", "id -u; id -g; id -G; touch /etc/worker-test"}}
+		startResponse, err := executeStartRequest(body, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job1, err := getJobFromResponse(startResponse)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job2, err := waitForJobStatus(job1.ID, worker.Errored, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := fmt.Sprintf("%s\n%s\n%s\n", id, id, id)
+		if job2.Stdout != expected || !strings.Contains(job2.Stderr, "Permission denied") {
+			t.Errorf("Expected the job of %s to run as %s without privileges, but got %q, %q", username, id, job2.Stdout, job2.Stderr)
+		}
+
+		if job2.Credential == nil || fmt.Sprint(job2.Credential.UID) != id {
+			t.Errorf("Expected the job to record the credential of %s, but got %+v", username, job2.Credential)
+		}
+	}
+}
+
+func TestUnmappedUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Jobs of unmapped users are only refused on a server running as root")
+	}
+
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	user2, err := server.authService.UserRepository.FindByUsername("user2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	user2.Credential = nil
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	body := map[string]interface{}{"Args": []string{"id", "-u"}}
+	response, err := executeStartRequest(body, "user2", "thisispasswordforuser2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status code 403 for a user without a Unix account, but got %d", response.StatusCode)
+	}
+	expectErrorMessage(response, "The user is not mapped to a Unix account, so its jobs cannot be run", t)
+
+	response, err = executeStartRequest(body, "user1", "thisispasswordforuser1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200 for a mapped user, but got %d", response.StatusCode)
+	}
+}
+
+func TestOutputLimits(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "worker-test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dataDir)
+
+	// The output left by a previous server with a memory store is removed
+	leftover := filepath.Join(dataDir, "output", "previous.stdout")
+	err = os.MkdirAll(filepath.Dir(leftover), 0700)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = ioutil.WriteFile(leftover, []byte("previous"), 0600)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	config := testServerConfig(8989)
+	config.DataDir = dataDir
+	config.OutputMemoryLimit = 1000
+	config.OutputHardLimit = 5000
+	server, err := NewServer(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	_, err = os.Stat(leftover)
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected the output left by a previous server to be removed, but got %v", err)
+	}
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	startResponse, err := executeStartJobRequest("head -c 10000 /dev/zero", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job2, err := waitForJobStatus(job1.ID, worker.Completed, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(job2.Stdout) != 5000 {
+		t.Errorf("Expected the output to be truncated to 5000 bytes, but got %d bytes", len(job2.Stdout))
+	}
+
+	if !job2.OutputTruncated || job2.OutputBytes != 10000 {
+		t.Errorf("Expected 10000 bytes of truncated output, but got %d bytes, truncated: %t", job2.OutputBytes, job2.OutputTruncated)
+	}
+
+	info, err := os.Stat(filepath.Join(dataDir, "output", job1.ID+".stdout"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if info.Size() != 4000 {
+		t.Errorf("Expected 4000 bytes of output on disk, but got %d", info.Size())
+	}
+
+	server.close()
+	_, err = os.Stat(filepath.Join(dataDir, "output", job1.ID+".stdout"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected the output on disk to be removed with the server, but got %v", err)
+	}
+}
+
+func TestOutputLimitKill(t *testing.T) {
+	config := testServerConfig(8989)
+	config.OutputHardLimit = 100000
+	config.OutputLimitPolicy = worker.OutputPolicyKill
+	server, err := NewServer(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	startResponse, err := executeStartJobRequest("yes", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job2, err := waitForJobStatus(job1.ID, worker.Errored, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The job only contains the end of its output, which is read whole from the logs
+	if !job2.OutputTruncated || len(job2.Stdout) != 64*1024 {
+		t.Errorf("Expected the last 64 KiB of truncated output, but got %d bytes, truncated: %t", len(job2.Stdout), job2.OutputTruncated)
+	}
+
+	logsResponse, err := executeLogsRequest(job1.ID, "stream=stdout", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	logs, err := parseResponse(logsResponse)
+	if err != nil || len(logs) != 100000 {
+		t.Errorf("Expected 100000 bytes of logs, but got %d bytes, %v", len(logs), err)
+	}
+
+	if job2.Signal != "SIGKILL" || job2.ExitReason != worker.ExitReasonSignaled {
+		t.Errorf("Expected the job to be terminated by SIGKILL, but got '%s', reason '%s'", job2.Signal, job2.ExitReason)
+	}
+}
+
+func TestFileJobStore(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "worker-test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dataDir)
+
+	config := testServerConfig(8989)
+	config.DataDir = dataDir
+	config.OutputMemoryLimit = 1000
+	config.JobStore = JobStoreFile
+	server, err := NewServer(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	startResponse, err := executeStartJobRequest("seq 1000", username, password)
+	if err != nil {
+		server.close()
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		server.close()
+		t.Error(err)
+		return
+	}
+
+	job2, err := waitForJobStatus(job1.ID, worker.Completed, username, password)
+	server.close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The job and its whole output are kept when the server restarts
+	server, err = NewServer(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	getResponse, err := executeGetJobRequest(job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job3, err := getJobFromResponse(getResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job3.Status != worker.Completed || job3.ExitCode == nil || *job3.ExitCode != 0 || job3.Command != "seq 1000" {
+		t.Errorf("Incorrect job after restarting the server: %+v", job3)
+	}
+
+	if job3.Stdout != job2.Stdout || job3.OutputBytes != job2.OutputBytes || len(job3.Stdout) <= 1000 {
+		t.Errorf("Expected %d bytes of output after restarting the server, but got %d", len(job2.Stdout), len(job3.Stdout))
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	command := "echo hello world"
+	startResponse, err := executeStartJobRequest(command, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err := executeGetJobRequest(job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, but got %d", response.StatusCode)
+	}
+
+	job2, err := getJobFromResponse(response)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job2.Status != worker.Completed {
+		t.Errorf("Expected the job status to be '%s', but got '%s'", worker.Completed, job2.Status)
+	}
+
+	if job2.Stdout != "hello world\n" {
+		t.Errorf(`The result for Stdout is not correct.
+Expected: %s
+Got: %s`, "hello world\n", job2.Stdout)
+	}
+
+	if job2.ExitCode == nil || *job2.ExitCode != 0 || job2.ExitReason != worker.ExitReasonExited {
+		t.Errorf("Expected the job to exit with code 0, but got exit code %v, reason '%s'", job2.ExitCode, job2.ExitReason)
+	}
+}
+
+func TestStreamLogs(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	body := map[string]interface{}{"Args": []string{"sh", "-c", "echo one; sleep 1; echo two >&2; sleep 1; echo three"}}
+	startResponse, err := executeStartRequest(body, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err := executeLogsRequest(job.ID, "follow=true", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, but got %d", response.StatusCode)
+	}
+
+	// The first line is received before the job has finished
+	first := make([]byte, 4)
+	_, err = io.ReadFull(response.Body, first)
+	if err != nil || string(first) != "one\n" {
+		t.Errorf("Expected to receive the first line, but got %q, %v", first, err)
+	}
+
+	getResponse, err := executeGetJobRequest(job.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	runningJob, err := getJobFromResponse(getResponse)
+	if err != nil || runningJob.Status != worker.Running {
+		t.Errorf("Expected the job to still be running while streaming, but got %+v, %v", runningJob, err)
+	}
+
+	// The response ends once the job has finished
+	rest, err := ioutil.ReadAll(response.Body)
+	if err != nil || string(rest) != "two\nthree\n" {
+		t.Errorf("Expected the rest of the output, but got %q, %v", rest, err)
+	}
+
+	response, err = executeLogsRequest(job.ID, "stream=stdout", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	stdout, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil || string(stdout) != "one\nthree\n" {
+		t.Errorf("Expected the stdout of the job, but got %q, %v", stdout, err)
+	}
+
+	response, err = executeLogsRequest(job.ID, "stream=invalid", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, but got %d", response.StatusCode)
+	}
+	expectErrorMessage(response, `The stream must be "stdout", "stderr" or "both"`, t)
+
+	response, err = executeLogsRequest(job.ID, "follow=true", "user2", "thisispasswordforuser2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404 for another user's job, but got %d", response.StatusCode)
+	}
+	expectErrorMessage(response, "Failed to find job", t)
+}
+
+func TestJobSocket(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	body := map[string]interface{}{"Args": []string{"sh", "-c", "echo one; sleep 1; echo two >&2; exit 3"}}
+	startResponse, err := executeStartRequest(body, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	conn, response, err := dialJobSocket(job.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	output := map[string]string{}
+	var statuses []worker.JobState
+	var exit *socketFrame
+	for exit == nil {
+		var frame socketFrame
+		err = conn.ReadJSON(&frame)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		switch frame.Type {
+		case frameStdout, frameStderr:
+			output[frame.Type] += frame.Data
+		case frameStatus:
+			statuses = append(statuses, frame.Status)
+		case frameExit:
+			exit = &frame
+		default:
+			t.Errorf("Unexpected frame type: %s", frame.Type)
+		}
+	}
+
+	if output[frameStdout] != "one\n" || output[frameStderr] != "two\n" {
+		t.Errorf("Incorrect output frames: %v", output)
+	}
+
+	if len(statuses) != 2 || statuses[0] != worker.Running || statuses[1] != worker.Errored {
+		t.Errorf("Expected the statuses running and errored, but got %v", statuses)
+	}
+
+	if exit.ExitReason != worker.ExitReasonExited || exit.ExitCode == nil || *exit.ExitCode != 3 {
+		t.Errorf("Incorrect exit frame: %+v", exit)
+	}
+
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected the socket to be closed once the job has finished, but got %v", err)
+	}
+
+	_, response, err = dialJobSocket(job.ID, "user2", "thisispasswordforuser2")
+	if err == nil || response == nil || response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404 for another user's job, but got %v, %v", response, err)
+	}
+
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	header := basicAuthHeader(username, password)
+	header.Set("Origin", "https://other.example.com")
+	_, response, err = dialer.Dial(makeURL("wss", 8989, "jobs/"+job.ID+"/ws"), header)
+	if err == nil || response == nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status code 403 from another origin, but got %v, %v", response, err)
+	}
+
+	_, response, err = dialJobSocket(job.ID, username, "wrongpassword")
+	if err == nil || response == nil || response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status code 401 with a wrong password, but got %v, %v", response, err)
+	}
+}
+
+func TestAttachJob(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	body := map[string]interface{}{"Args": []string{"sh", "-c", "echo ready; read line; echo got $line; stty size"}, "TTY": true}
+	startResponse, err := executeStartRequest(body, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	first, _, err := dialAttachSocket(job.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, _, err = readAttachOutput(first, "ready")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Detaching leaves the job running
+	first.Close()
+	time.Sleep(100 * time.Millisecond)
+	job, err = waitForJobStatus(job.ID, worker.Running, username, password)
+	if err != nil {
+		t.Errorf("Expected the job to keep running once the client has detached: %v", err)
+		return
+	}
+
+	var viewers []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := dialAttachSocket(job.ID, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		// The output written before attaching is sent first
+		_, _, err = readAttachOutput(conn, "ready")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		viewers = append(viewers, conn)
+	}
+
+	err = viewers[0].WriteJSON(attachMessage{Type: attachResize, Rows: 30, Cols: 90})
+	if err == nil {
+		err = viewers[0].WriteMessage(websocket.BinaryMessage, []byte("hello\n"))
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, conn := range viewers {
+		output, exit, err := readAttachOutput(conn, "")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if !strings.Contains(output, "got hello\r\n30 90") {
+			t.Errorf("Expected every viewer to get the output of the input and the new size, but got %q", output)
+		}
+
+		if exit.Status != worker.Completed || exit.ExitCode == nil || *exit.ExitCode != 0 {
+			t.Errorf("Incorrect exit frame: %+v", exit)
+		}
+
+		_, _, err = conn.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Errorf("Expected the socket to be closed once the job has finished, but got %v", err)
+		}
+	}
+
+	_, response, err := dialAttachSocket(job.ID, "user2", "thisispasswordforuser2")
+	if err == nil || response == nil || response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404 for another user's job, but got %v, %v", response, err)
+	}
+
+	startResponse, err = executeStartJobRequest("true", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err = getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, response, err = dialAttachSocket(job.ID, username, password)
+	if err == nil || response == nil || response.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status code 409 for a job without a terminal, but got %v, %v", response, err)
+	}
+}
+
+func TestStdin(t *testing.T) {
+	config := testServerConfig(8989)
+	config.MaxStdinBytes = 64 * 1024
+	server, err := NewServer(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	startResponse, err := executeStartRequest(map[string]interface{}{"Args": []string{"cat"}, "Stdin": "inline\n"}, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err = waitForJobStatus(job.ID, worker.Completed, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job.Stdout != "inline\n" {
+		t.Errorf("Expected the job to read the inline stdin, but got %q", job.Stdout)
+	}
+
+	upload := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	startResponse, err = executeMultipartStartRequest(`{"Args": ["cat"]}`, upload, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err = getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err = waitForJobStatus(job.ID, worker.Completed, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job.Stdout != string(upload) {
+		t.Errorf("Expected the job to read the uploaded stdin, but got %d bytes", len(job.Stdout))
+	}
+
+	response, err := executeMultipartStartRequest(`{"Args": ["cat"]}`, append(upload, 'x'), username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code 413 for a stdin larger than the limit, but got %d", response.StatusCode)
+	}
+
+	startResponse, err = executeStartRequest(map[string]interface{}{"Args": []string{"cat"}, "OpenStdin": true}, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err = getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err = executeStdinRequest(job.ID, "close=false", "one\n", username, password)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200 when writing to the stdin, but got %v, %v", response, err)
+		return
+	}
+
+	_, err = waitForJobStatus(job.ID, worker.Running, username, password)
+	if err != nil {
+		t.Errorf("Expected the job to keep running while its stdin is open: %v", err)
+		return
+	}
+
+	response, err = executeStdinRequest(job.ID, "", "two\n", username, password)
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200 when writing to the stdin, but got %v, %v", response, err)
+		return
+	}
+
+	job, err = waitForJobStatus(job.ID, worker.Completed, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job.Stdout != "one\ntwo\n" {
+		t.Errorf("Expected the job to read the streamed stdin until it was closed, but got %q", job.Stdout)
+	}
+
+	response, err = executeStdinRequest(job.ID, "", "three\n", username, password)
+	if err != nil || response.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status code 409 once the stdin is closed, but got %v, %v", response, err)
+	}
+
+	response, err = executeStdinRequest(job.ID, "", "three\n", "user2", "thisispasswordforuser2")
+	if err != nil || response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404 for another user's job, but got %v, %v", response, err)
+	}
+
+	response, err = executeStartRequest(map[string]interface{}{"Args": []string{"cat"}, "OpenStdin": true, "Stdin": "both"}, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectErrorMessage(response, "Invalid stdin", t)
+}
+
+func TestJobEvents(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	response, err := executeEventsRequest("", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer response.Body.Close()
+	events := bufio.NewReader(response.Body)
+
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected an event stream, but got status code %d, %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	_, err = executeStartJobRequest("echo other user", "user2", "thisispasswordforuser2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	startResponse, err := executeStartJobRequest("echo hello", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Only the events of the user's job are sent
+	var ids []string
+	expected := []worker.JobState{worker.Pending, worker.Running, worker.Completed}
+	for _, status := range expected {
+		id, eventType, event, err := readServerEvent(events)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if event.JobID != job.ID || event.Status != status || eventType != event.Type {
+			t.Errorf("Expected the %s event of job %s, but got %s %+v", status, job.ID, eventType, event)
+		}
+		ids = append(ids, id)
+	}
+
+	// Resuming after the first event sends the next ones again
+	resumed, err := executeEventsRequest(ids[0], username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resumed.Body.Close()
+	resumedEvents := bufio.NewReader(resumed.Body)
+
+	for _, id := range ids[1:] {
+		resumedID, _, _, err := readServerEvent(resumedEvents)
+		if err != nil || resumedID != id {
+			t.Errorf("Expected to resume with event %s, but got %s, %v", id, resumedID, err)
+		}
+	}
+
+	unknown, err := executeEventsRequest("1", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer unknown.Body.Close()
+
+	_, eventType, _, err := readServerEvent(bufio.NewReader(unknown.Body))
+	if err != nil || eventType != "reset" {
+		t.Errorf("Expected a reset event for an unknown event ID, but got %s, %v", eventType, err)
+	}
+}
+
+func TestListJobs(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	var jobIDs []string
+	for _, body := range []map[string]interface{}{
+		{"Command": "echo one", "Labels": map[string]string{"team": "a"}},
+		{"Command": "echo two", "Labels": map[string]string{"team": "b"}},
+		{"Command": "sleep 30", "Labels": map[string]string{"team": "a", "env": "test"}},
+	} {
+		response, err := executeStartRequest(body, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job, err := getJobFromResponse(response)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		jobIDs = append(jobIDs, job.ID)
+	}
+	defer executeStopJobRequest(jobIDs[2], username, password)
+
+	_, err = executeStartJobRequest("echo other user", "user2", "thisispasswordforuser2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, id := range jobIDs[:2] {
+		_, err = waitForJobStatus(id, worker.Completed, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Newest first, two jobs per page
+	page, err := listJobs("limit=2", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(page.Jobs) != 2 || page.Jobs[0].ID != jobIDs[2] || page.Jobs[1].ID != jobIDs[1] || page.NextCursor == "" {
+		t.Errorf("Incorrect first page: %+v", page)
+	}
+
+	if page.Jobs[0].Stdout != "" || page.Jobs[0].CreatedAt.IsZero() {
+		t.Errorf("Expected listed jobs to have a creation time and no output, but got %+v", page.Jobs[0])
+	}
+
+	page, err = listJobs("limit=2&cursor="+page.NextCursor, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(page.Jobs) != 1 || page.Jobs[0].ID != jobIDs[0] || page.NextCursor != "" {
+		t.Errorf("Incorrect last page: %+v", page)
+	}
+
+	queries := map[string][]string{
+		"order=asc":                           jobIDs,
+		"status=completed":                    {jobIDs[1], jobIDs[0]},
+		"status=running,stopped":              {jobIDs[2]},
+		"command=echo":                        {jobIDs[1], jobIDs[0]},
+		"label=team%3Da":                      {jobIDs[2], jobIDs[0]},
+		"label=team%3Da&label=env":            {jobIDs[2]},
+		"created_before=2000-01-01T00:00:00Z": nil,
+	}
+	for query, expected := range queries {
+		page, err = listJobs(query, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var ids []string
+		for _, job := range page.Jobs {
+			ids = append(ids, job.ID)
+		}
+
+		if strings.Join(ids, ",") != strings.Join(expected, ",") {
+			t.Errorf("Incorrect jobs for %s.\nExpected: %v\nGot: %v", query, expected, ids)
+		}
+	}
+
+	invalidQueries := map[string]string{
+		"status=done":         "Invalid status: done",
+		"created_after=today": "created_after must be an RFC3339 time",
+		"limit=0":             "The limit must be between 1 and 500",
+		"cursor=notacursor":   "Invalid cursor",
+		"order=random":        `The order must be "asc" or "desc"`,
+	}
+	for query, message := range invalidQueries {
+		request, err := http.NewRequest("GET", makeURL("https", 8989, "/jobs?"+query), nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		response, err := executeRequest(request, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code 400 for %s, but got %d", query, response.StatusCode)
+		}
+
+		expectErrorMessage(response, message, t)
+	}
+}
+
+func TestJobTimestamps(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	startResponse, err := executeStartJobRequest("sleep 0.5", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job1.StartedAt == nil || job1.StartedAt.Before(job1.CreatedAt) || job1.FinishedAt != nil {
+		t.Errorf("Expected a started job without finish time, but got created: %v, started: %v, finished: %v", job1.CreatedAt, job1.StartedAt, job1.FinishedAt)
+	}
+
+	job2, err := waitForJobStatus(job1.ID, worker.Completed, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job2.FinishedAt == nil || !job2.StartedAt.Equal(*job1.StartedAt) {
+		t.Errorf("Expected a finished job, but got started: %v, finished: %v", job2.StartedAt, job2.FinishedAt)
+		return
+	}
+
+	duration := time.Duration(job2.Duration)
+	if duration != job2.FinishedAt.Sub(*job2.StartedAt) || duration < 500*time.Millisecond {
+		t.Errorf("Expected the duration to be the time between start and finish, but got %s", duration)
+	}
+
+	// The failed job has finished without starting
+	_, err = executeStartJobRequest("nonexistentcommand", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	query := "sort=finished_at&started_after=" + job1.CreatedAt.Add(-time.Second).Format(time.RFC3339)
+	page, err := listJobs(query, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(page.Jobs) != 1 || page.Jobs[0].ID != job1.ID {
+		t.Errorf("Expected to only list the started job, but got %+v", page.Jobs)
+	}
+
+	page, err = listJobs("sort=finished_at&limit=1", username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(page.Jobs) != 1 || page.Jobs[0].StartedAt != nil || page.Jobs[0].FinishedAt == nil {
+		t.Errorf("Expected the failed job to have finished last, but got %+v", page.Jobs)
+	}
+}
+
+func TestJobUsage(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	command := []string{"sh", "-c", "i=0; while [ $i -lt 300000 ]; do i=$((i+1)); done; sleep 1"}
+	startResponse, err := executeStartRequest(map[string]interface{}{"Args": command}, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	getResponse, err := executeGetJobRequest(job1.ID, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job2, err := getJobFromResponse(getResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job2.Status == worker.Running && (job2.Usage == nil || !job2.Usage.Sampled || job2.Usage.MaxRSS == 0) {
+		t.Errorf("Expected the sampled usage of the running job, but got %+v", job2.Usage)
+	}
+
+	job3, err := waitForJobStatus(job1.ID, worker.Completed, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	usage := job3.Usage
+	if usage == nil || usage.Sampled || usage.UserCPU+usage.SystemCPU == 0 || usage.MaxRSS == 0 || usage.VoluntaryContextSwitches == 0 {
+		t.Errorf("Expected the usage of the finished job, but got %+v", usage)
+	}
+}
+
+func TestPlainHTTP(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	response, err := executePlainTextRequest()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code to be 400. Got: %d", response.StatusCode)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	response, err := executeStartJobRequest("echo hello world", "user1", "anIncorrectPassword")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status code 401, but got %d", response.StatusCode)
+	}
+
+	expectErrorMessage(response, "Unable to authenticate user", t)
+}
+
+func TestAuthorization(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	startResponse, err := executeStartJobRequest("echo hello world", "user1", "thisispasswordforuser1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	job1, err := getJobFromResponse(startResponse)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	response, err := executeGetJobRequest(job1.ID, "user2", "thisispasswordforuser2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status code 404, but got %d", response.StatusCode)
 	}
+
+	expectErrorMessage(response, "Failed to find job", t)
 }
 
-func TestGetJob(t *testing.T) {
+func TestInvalidCommand(t *testing.T) {
 	server, err := NewServer(testServerConfig(8989))
 	if err != nil {
 		t.Error(err)
@@ -121,55 +1822,197 @@ func TestGetJob(t *testing.T) {
 
 	go server.Run()
 	defer server.close()
+	waitForServer(8989)
 
-	username := "user1"
-	password := "thisispasswordforuser1"
-
-	command := "echo hello world"
-	startResponse, err := executeStartJobRequest(command, username, password)
+	command := "an invalid command"
+	response, err := executeStartJobRequest(command, "user1", "thisispasswordforuser1")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	job1, err := getJobFromResponse(startResponse)
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected status code 500, but got %d", response.StatusCode)
+	}
+
+	expectErrorMessage(response, "Failed to start job", t)
+}
+
+func TestCommandArguments(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	response, err := executeGetJobRequest(job1.ID, username, password)
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	tests := []struct {
+		body     map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"Args": []string{"echo", "hello  world", "'a'"}}, "hello  world 'a'\n"},
+		{map[string]interface{}{"Command": `echo "hello  world" 'a b'`, "ShellSplit": true}, "hello  world a b\n"},
+		{map[string]interface{}{"Command": "echo  hello   world"}, "hello world\n"},
+	}
+
+	for _, test := range tests {
+		startResponse, err := executeStartRequest(test.body, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job1, err := getJobFromResponse(startResponse)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job2, err := waitForJobStatus(job1.ID, worker.Completed, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if job2.Stdout != test.expected {
+			t.Errorf("The result for Stdout is not correct.\nExpected: %q\nGot: %q", test.expected, job2.Stdout)
+		}
+	}
+}
+
+func TestInvalidCommandArguments(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if response.StatusCode != http.StatusOK {
-		t.Errorf("Expected status code 200, but got %d", response.StatusCode)
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	bodies := []map[string]interface{}{
+		{"Command": `echo "hello`, "ShellSplit": true},
+		{"Command": "echo", "Args": []string{"echo"}},
+		{"Command": "   "},
 	}
 
-	job2, err := getJobFromResponse(response)
+	for _, body := range bodies {
+		response, err := executeStartRequest(body, "user1", "thisispasswordforuser1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code 400 for %v, but got %d", body, response.StatusCode)
+		}
+
+		expectErrorMessage(response, "Invalid command", t)
+	}
+}
+
+func TestEnvironmentAndWorkDir(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if job2.Status != worker.Completed {
-		t.Errorf("Expected the job status to be '%s', but got '%s'", worker.Completed, job2.Status)
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	tests := []struct {
+		body     map[string]interface{}
+		expected string
+	}{
+		{
+			map[string]interface{}{"Args": []string{"sh", "-c", "echo $FOO; pwd"}, "Env": []string{"FOO=a=b"}, "WorkDir": "/tmp"},
+			"a=b\n/tmp\n",
+		},
+		{
+			map[string]interface{}{"Args": []string{"env"}, "Env": []string{"PATH=/usr/bin:/bin", "A=1"}, "CleanEnv": true},
+			"PATH=/usr/bin:/bin\nA=1\n",
+		},
 	}
 
-	if job2.Stdout != "hello world\n" {
-		t.Errorf(`The result for Stdout is not correct.
-Expected: %s
-Got: %s`, "hello world\n", job2.Stdout)
+	for _, test := range tests {
+		startResponse, err := executeStartRequest(test.body, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job1, err := getJobFromResponse(startResponse)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job2, err := waitForJobStatus(job1.ID, worker.Completed, username, password)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if job2.Stdout != test.expected {
+			t.Errorf("The result for Stdout is not correct.\nExpected: %q\nGot: %q", test.expected, job2.Stdout)
+		}
+
+		if len(job2.Env) != len(test.body["Env"].([]string)) {
+			t.Errorf("Expected the job to record its environment. Got: %v", job2.Env)
+		}
+	}
+}
+
+func TestInvalidEnvironmentAndWorkDir(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	tests := []struct {
+		body    map[string]interface{}
+		message string
+	}{
+		{map[string]interface{}{"Command": "env", "Env": []string{"1A=b"}}, "Environment variables must have the format KEY=VALUE"},
+		{map[string]interface{}{"Command": "env", "Env": []string{"A"}}, "Environment variables must have the format KEY=VALUE"},
+		{map[string]interface{}{"Command": "env", "Env": []string{"LD_PRELOAD=/tmp/x.so"}}, "Environment variables cannot set the dynamic loader's LD_ variables"},
+		{map[string]interface{}{"Command": "pwd", "WorkDir": "tmp"}, "The working directory must be an absolute path"},
+		{map[string]interface{}{"Command": "pwd", "WorkDir": "/does/not/exist"}, "The working directory does not exist or cannot be accessed by the job"},
 	}
 
-	if job2.ExitCode != "0" {
-		t.Errorf("Expected the job exit code to be 0, but got %s", job2.ExitCode)
+	for _, test := range tests {
+		response, err := executeStartRequest(test.body, "user1", "thisispasswordforuser1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code 400, but got %d", response.StatusCode)
+		}
+
+		expectErrorMessage(response, test.message, t)
 	}
 }
 
-func TestPlainHTTP(t *testing.T) {
+func TestResourceLimits(t *testing.T) {
 	server, err := NewServer(testServerConfig(8989))
 	if err != nil {
 		t.Error(err)
@@ -178,19 +2021,45 @@ func TestPlainHTTP(t *testing.T) {
 
 	go server.Run()
 	defer server.close()
+	waitForServer(8989)
 
-	response, err := executePlainTextRequest()
+	body := map[string]interface{}{
+		"Command": "sleep 1",
+		"Limits":  map[string]string{"MemoryMax": "64M", "PidsMax": "16"},
+	}
+	response, err := executeStartRequest(body, "user1", "thisispasswordforuser1")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if response.StatusCode != http.StatusBadRequest {
-		t.Errorf("Expected status code to be 400. Got: %d", response.StatusCode)
+	_, err = os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	if err != nil {
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code 400 without cgroup v2, but got %d", response.StatusCode)
+		}
+
+		expectErrorMessage(response, "Resource limits are not supported by the server", t)
+		return
+	}
+
+	job, err := getJobFromResponse(response)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job.Limits.MemoryMax != "64M" || job.Limits.PidsMax != "16" {
+		t.Errorf("The limits in the response are not correct. Got: %+v", job.Limits)
 	}
 }
 
-func TestAuthentication(t *testing.T) {
+func TestOOMKilled(t *testing.T) {
+	_, err := os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	if err != nil {
+		t.Skip("Memory limits require cgroup v2")
+	}
+
 	server, err := NewServer(testServerConfig(8989))
 	if err != nil {
 		t.Error(err)
@@ -199,21 +2068,44 @@ func TestAuthentication(t *testing.T) {
 
 	go server.Run()
 	defer server.close()
+	waitForServer(8989)
 
-	response, err := executeStartJobRequest("echo hello world", "user1", "anIncorrectPassword")
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	// tail keeps the whole line in memory
+	body := map[string]interface{}{
+		"Args":   []string{"sh", "-c", "head -c 512M /dev/zero | tail"},
+		"Limits": map[string]string{"MemoryMax": "16M"},
+	}
+	response, err := executeStartRequest(body, username, password)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if response.StatusCode != http.StatusUnauthorized {
-		t.Errorf("Expected status code 401, but got %d", response.StatusCode)
+	job1, err := getJobFromResponse(response)
+	if err != nil {
+		t.Error(err)
+		return
 	}
 
-	expectErrorMessage(response, "Unable to authenticate user", t)
+	job2, err := waitForJobStatus(job1.ID, worker.Errored, username, password)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if job2.ExitReason != worker.ExitReasonOOMKilled {
+		t.Errorf("Expected the exit reason to be '%s', but got '%s'", worker.ExitReasonOOMKilled, job2.ExitReason)
+	}
 }
 
-func TestAuthorization(t *testing.T) {
+func TestIsolation(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating namespaces requires root")
+	}
+
 	server, err := NewServer(testServerConfig(8989))
 	if err != nil {
 		t.Error(err)
@@ -222,8 +2114,13 @@ func TestAuthorization(t *testing.T) {
 
 	go server.Run()
 	defer server.close()
+	waitForServer(8989)
 
-	startResponse, err := executeStartJobRequest("echo hello world", "user1", "thisispasswordforuser1")
+	username := "user1"
+	password := "thisispasswordforuser1"
+
+	body := map[string]interface{}{"Command": "cat /proc/self/stat", "Isolation": worker.IsolationNamespaces}
+	startResponse, err := executeStartRequest(body, username, password)
 	if err != nil {
 		t.Error(err)
 		return
@@ -235,20 +2132,23 @@ func TestAuthorization(t *testing.T) {
 		return
 	}
 
-	response, err := executeGetJobRequest(job1.ID, "user2", "thisispasswordforuser2")
+	job2, err := waitForJobStatus(job1.ID, worker.Completed, username, password)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if response.StatusCode != http.StatusNotFound {
-		t.Errorf("Expected status code 404, but got %d", response.StatusCode)
+	// The parent of the command is the init of the namespace, PID 1
+	if !strings.Contains(job2.Stdout, " (cat) R 1 ") {
+		t.Errorf("Expected the command to run under the init of its PID namespace with its own /proc. Got: %s", job2.Stdout)
 	}
 
-	expectErrorMessage(response, "Failed to find job", t)
+	if job2.Isolation != worker.IsolationNamespaces {
+		t.Errorf("Expected the job isolation to be '%s', but got '%s'", worker.IsolationNamespaces, job2.Isolation)
+	}
 }
 
-func TestInvalidCommand(t *testing.T) {
+func TestInvalidIsolation(t *testing.T) {
 	server, err := NewServer(testServerConfig(8989))
 	if err != nil {
 		t.Error(err)
@@ -257,19 +2157,177 @@ func TestInvalidCommand(t *testing.T) {
 
 	go server.Run()
 	defer server.close()
+	waitForServer(8989)
 
-	command := "an invalid command"
-	response, err := executeStartJobRequest(command, "user1", "thisispasswordforuser1")
+	body := map[string]interface{}{"Command": "ls", "Isolation": "vm"}
+	response, err := executeStartRequest(body, "user1", "thisispasswordforuser1")
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if response.StatusCode != http.StatusInternalServerError {
-		t.Errorf("Expected status code 500, but got %d", response.StatusCode)
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, but got %d", response.StatusCode)
 	}
 
-	expectErrorMessage(response, "Failed to start job", t)
+	expectErrorMessage(response, "Invalid isolation mode", t)
+}
+
+func TestSecurityProfile(t *testing.T) {
+	server, err := NewServer(testServerConfig(8989))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	user2, err := server.authService.UserRepository.FindByUsername("user2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	user2.SecurityProfile = worker.SecurityProfileStrict
+
+	go server.Run()
+	defer server.close()
+	waitForServer(8989)
+
+	tests := []struct {
+		username  string
+		requested string
+		expected  string
+	}{
+		{"user1", "", worker.SecurityProfileDefault},
+		{"user1", worker.SecurityProfileStrict, worker.SecurityProfileStrict},
+		{"user2", "", worker.SecurityProfileStrict},
+	}
+	for _, test := range tests {
+		body := map[string]interface{}{"Args": []string{"grep", "Seccomp:", "/proc/self/status"}, "SecurityProfile": test.requested}
+		startResponse, err := executeStartRequest(body, test.username, "thisispasswordfor"+test.username)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job1, err := getJobFromResponse(startResponse)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		job2, err := waitForJobStatus(job1.ID, worker.Completed, test.username, "thisispasswordfor"+test.username)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if job2.SecurityProfile != test.expected || job2.Stdout != "Seccomp:\t2\n" {
+			t.Errorf("Expected the job of %s to run with the %s profile, but got %s with %q", test.username, test.expected, job2.SecurityProfile, job2.Stdout)
+		}
+	}
+
+	invalidRequests := []struct {
+		username  string
+		requested string
+		message   string
+	}{
+		{"user1", worker.SecurityProfileUnconfined, "The security profile cannot be less restrictive than default"},
+		{"user2", worker.SecurityProfileDefault, "The security profile cannot be less restrictive than strict"},
+		{"user1", "relaxed", "Invalid security profile"},
+	}
+	for _, request := range invalidRequests {
+		body := map[string]interface{}{"Command": "true", "SecurityProfile": request.requested}
+		response, err := executeStartRequest(body, request.username, "thisispasswordfor"+request.username)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status code 400, but got %d", response.StatusCode)
+		}
+
+		expectErrorMessage(response, request.message, t)
+	}
+}
+
+// waitForJobStatus polls the job until it has the given status
+func waitForJobStatus(jobID string, status worker.JobState, username, password string) (*worker.Job, error) {
+	var job *worker.Job
+	for i := 0; i < 100; i++ {
+		response, err := executeGetJobRequest(jobID, username, password)
+		if err != nil {
+			return nil, err
+		}
+
+		job, err = getJobFromResponse(response)
+		if err != nil {
+			return nil, err
+		}
+
+		if job.Status == status {
+			return job, nil
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return nil, fmt.Errorf("Expected the job status to be '%s', but got '%s'", status, job.Status)
+}
+
+// waitForJobOutput waits until the stdout of the job contains s
+func waitForJobOutput(jobID, s, username, password string) (*worker.Job, error) {
+	var job *worker.Job
+	for i := 0; i < 100; i++ {
+		response, err := executeGetJobRequest(jobID, username, password)
+		if err != nil {
+			return nil, err
+		}
+
+		job, err = getJobFromResponse(response)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Contains(job.Stdout, s) {
+			return job, nil
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return nil, fmt.Errorf("Expected the job output to contain %q, but got %q", s, job.Stdout)
+}
+
+// processRunning returns true if a process that is not a zombie has the given command line.
+// The arguments in cmdline are separated by null bytes.
+func processRunning(cmdline string) bool {
+	paths, _ := filepath.Glob("/proc/[0-9]*")
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(filepath.Join(path, "cmdline"))
+		if err != nil || strings.TrimRight(string(content), "\x00") != cmdline {
+			continue
+		}
+
+		stat, err := ioutil.ReadFile(filepath.Join(path, "stat"))
+		if err == nil && !strings.Contains(string(stat), ") Z ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func waitForServer(port int) {
+	address := fmt.Sprintf("localhost:%d", port)
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			conn.Close()
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
 }
 
 func testServerConfig(port int) ServerConfig {
@@ -281,9 +2339,11 @@ func testServerConfig(port int) ServerConfig {
 }
 
 func executeStartJobRequest(command, username, password string) (*http.Response, error) {
-	requestBody, err := json.Marshal(map[string]string{
-		"Command": command,
-	})
+	return executeStartRequest(map[string]interface{}{"Command": command}, username, password)
+}
+
+func executeStartRequest(body map[string]interface{}, username, password string) (*http.Response, error) {
+	requestBody, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
@@ -296,10 +2356,53 @@ func executeStartJobRequest(command, username, password string) (*http.Response,
 	return executeRequest(request, username, password)
 }
 
+func executeMultipartStartRequest(job string, stdin []byte, username, password string) (*http.Response, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	err := writer.WriteField("job", job)
+	if err != nil {
+		return nil, err
+	}
+
+	part, err := writer.CreateFormFile("stdin", "stdin")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = part.Write(stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("POST", makeURL("https", 8989, "start"), &body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	return executeRequest(request, username, password)
+}
+
+func executeStdinRequest(jobID, query, body, username, password string) (*http.Response, error) {
+	request, err := http.NewRequest("POST", makeURL("https", 8989, "jobs/"+jobID+"/stdin?"+query), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	return executeRequest(request, username, password)
+}
+
 func executeStopJobRequest(jobID, username, password string) (*http.Response, error) {
-	requestBody, err := json.Marshal(map[string]string{
-		"ID": jobID,
-	})
+	return executeStopRequest(map[string]interface{}{"ID": jobID}, username, password)
+}
+
+func executeStopRequest(body map[string]interface{}, username, password string) (*http.Response, error) {
+	requestBody, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
@@ -312,6 +2415,35 @@ func executeStopJobRequest(jobID, username, password string) (*http.Response, er
 	return executeRequest(request, username, password)
 }
 
+// executePauseRequest sends a request to the /pause or /resume endpoint
+func executePauseRequest(action, jobID, username, password string) (*http.Response, error) {
+	requestBody, err := json.Marshal(map[string]interface{}{"ID": jobID})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("PUT", makeURL("https", 8989, action), bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	return executeRequest(request, username, password)
+}
+
+func executeSignalRequest(jobID, signal, username, password string) (*http.Response, error) {
+	requestBody, err := json.Marshal(map[string]interface{}{"ID": jobID, "Signal": signal})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("PUT", makeURL("https", 8989, "signal"), bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	return executeRequest(request, username, password)
+}
+
 func executeGetJobRequest(jobID, username, password string) (*http.Response, error) {
 	path := "/jobs/" + jobID
 
@@ -323,6 +2455,121 @@ func executeGetJobRequest(jobID, username, password string) (*http.Response, err
 	return executeRequest(request, username, password)
 }
 
+func executeLogsRequest(jobID, query, username, password string) (*http.Response, error) {
+	request, err := http.NewRequest("GET", makeURL("https", 8989, "jobs/"+jobID+"/logs?"+query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return executeRequest(request, username, password)
+}
+
+func dialJobSocket(jobID, username, password string) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	return dialer.Dial(makeURL("wss", 8989, "jobs/"+jobID+"/ws"), basicAuthHeader(username, password))
+}
+
+func dialAttachSocket(jobID, username, password string) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	return dialer.Dial(makeURL("wss", 8989, "jobs/"+jobID+"/attach"), basicAuthHeader(username, password))
+}
+
+// readAttachOutput reads the output sent on an attach socket until it contains until. With an empty until,
+// reads until the exit frame.
+func readAttachOutput(conn *websocket.Conn, until string) (string, *socketFrame, error) {
+	var output strings.Builder
+	for until == "" || !strings.Contains(output.String(), until) {
+		messageType, p, err := conn.ReadMessage()
+		if err != nil {
+			return output.String(), nil, err
+		}
+
+		if messageType == websocket.BinaryMessage {
+			output.Write(p)
+			continue
+		}
+
+		var frame socketFrame
+		err = json.Unmarshal(p, &frame)
+		if err != nil {
+			return output.String(), nil, err
+		}
+
+		if frame.Type == frameExit {
+			return output.String(), &frame, nil
+		}
+	}
+
+	return output.String(), nil, nil
+}
+
+func basicAuthHeader(username, password string) http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	return header
+}
+
+func executeEventsRequest(lastEventID, username, password string) (*http.Response, error) {
+	request, err := http.NewRequest("GET", makeURL("https", 8989, "events"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastEventID != "" {
+		request.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	return executeRequest(request, username, password)
+}
+
+// readServerEvent reads the next event of an event stream, skipping the comments
+func readServerEvent(reader *bufio.Reader) (string, string, worker.Event, error) {
+	var id, eventType string
+	var event worker.Event
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return id, eventType, event, err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && eventType != "":
+			return id, eventType, event, nil
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+			if err != nil {
+				return id, eventType, event, err
+			}
+		}
+	}
+}
+
+func listJobs(query, username, password string) (worker.JobPage, error) {
+	request, err := http.NewRequest("GET", makeURL("https", 8989, "/jobs?"+query), nil)
+	if err != nil {
+		return worker.JobPage{}, err
+	}
+
+	response, err := executeRequest(request, username, password)
+	if err != nil {
+		return worker.JobPage{}, err
+	}
+
+	body, err := parseResponse(response)
+	if err != nil {
+		return worker.JobPage{}, err
+	}
+
+	var page worker.JobPage
+	err = json.Unmarshal(body, &page)
+	return page, err
+}
+
 func executePlainTextRequest() (*http.Response, error) {
 	path := "/jobs/123"
 
@@ -1,31 +1,53 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
+	"github.com/tmnhat2001/worker-service/internal/worker"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// User represents a user that has access to the API
+// errUnmappedUser represents an error returned when a server running as root is asked to run the job of a user
+// which is not mapped to a Unix account
+var errUnmappedUser = errors.New("The user is not mapped to a Unix account, so its jobs cannot be run")
+
+// User represents a user that has access to the API. The jobs of the user run as the Unix account
+// of Credential. A user without one cannot run jobs on a server running as root, and its jobs run as the
+// server otherwise. SecurityProfile is the security profile of the user's jobs,
+// worker.SecurityProfileDefault if it is empty: a job can only ask for a more restrictive profile.
 type User struct {
-	Username     string
-	PasswordHash []byte
+	Username        string
+	PasswordHash    []byte
+	Credential      *worker.Credential
+	SecurityProfile string
 }
 
-// createUsers creates dummy users for manual testing
+// createUsers creates dummy users for manual testing. Their jobs run as the uid and gid 1001 and 1002.
 func createUsers() (map[string]*User, error) {
 	users := make(map[string]*User)
 	usernames := []string{"user1", "user2"}
-	for _, username := range usernames {
+	for i, username := range usernames {
 		password := fmt.Sprintf("thisispasswordfor%s", username)
 		passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), BcryptCostFactor)
 		if err != nil {
 			return nil, err
 		}
 
-		user := User{Username: username, PasswordHash: passwordHash}
+		id := uint32(1001 + i)
+		user := User{Username: username, PasswordHash: passwordHash, Credential: &worker.Credential{UID: id, GID: id}}
 		users[username] = &user
 	}
 
 	return users, nil
 }
+
+// checkCredential returns errUnmappedUser if the jobs of the user would run as root
+func (user *User) checkCredential() error {
+	if user.Credential == nil && os.Geteuid() == 0 {
+		return errUnmappedUser
+	}
+
+	return nil
+}
@@ -38,3 +38,17 @@ func jsonResponse(w http.ResponseWriter, payload interface{}, statusCode int) {
 	w.WriteHeader(statusCode)
 	w.Write(response)
 }
+
+// flushWriter sends each write to the client right away, as a chunk of the response
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (writer flushWriter) Write(p []byte) (int, error) {
+	n, err := writer.w.Write(p)
+	if flusher, ok := writer.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return n, err
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tmnhat2001/worker-service/internal/worker"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+
+const (
+	maxLabels          = 32
+	maxLabelValueBytes = 256
+)
+
+// validateStartRequest checks the parameters of a job sent to the /start endpoint.
+// The error messages can be returned to the user.
+func validateStartRequest(job worker.Job) error {
+	err := validateEnvironment(job.Env)
+	if err != nil {
+		return err
+	}
+
+	err = validateLabels(job.Labels)
+	if err != nil {
+		return err
+	}
+
+	return validateWorkDir(job.WorkDir)
+}
+
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("A job cannot have more than %d labels", maxLabels)
+	}
+
+	for key, value := range labels {
+		if !labelKeyPattern.MatchString(key) {
+			return errors.New("Label keys must have up to 63 letters, digits, '.', '_', '/' or '-', and start with a letter or digit")
+		}
+
+		if len(value) > maxLabelValueBytes || strings.ContainsRune(value, 0) {
+			return fmt.Errorf("Label values must have up to %d bytes", maxLabelValueBytes)
+		}
+	}
+
+	return nil
+}
+
+func validateEnvironment(env []string) error {
+	for _, variable := range env {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 || !envNamePattern.MatchString(parts[0]) || strings.ContainsRune(parts[1], 0) {
+			return errors.New("Environment variables must have the format KEY=VALUE")
+		}
+
+		// The dynamic loader's variables, such as LD_PRELOAD, could run code in the server's processes
+		if strings.HasPrefix(parts[0], "LD_") {
+			return errors.New("Environment variables cannot set the dynamic loader's LD_ variables")
+		}
+	}
+
+	return nil
+}
+
+func validateWorkDir(workDir string) error {
+	if workDir == "" {
+		return nil
+	}
+
+	// Whether the job's account can access the directory is checked by the worker when the job starts
+	if !filepath.IsAbs(workDir) || strings.ContainsRune(workDir, 0) {
+		return errors.New("The working directory must be an absolute path")
+	}
+
+	return nil
+}
+
+// jobTimeout returns the timeout of a job given the requested timeout and the server's configuration
+func jobTimeout(requested worker.Duration, config ServerConfig) (worker.Duration, error) {
+	timeout := time.Duration(requested)
+	if timeout < 0 {
+		return 0, errors.New("The timeout cannot be negative")
+	}
+
+	if timeout == 0 {
+		timeout = config.DefaultJobTimeout
+	}
+
+	if config.MaxJobTimeout > 0 {
+		if timeout == 0 {
+			timeout = config.MaxJobTimeout
+		} else if timeout > config.MaxJobTimeout {
+			return 0, fmt.Errorf("The timeout cannot be longer than %s", config.MaxJobTimeout)
+		}
+	}
+
+	return worker.Duration(timeout), nil
+}
+
+// jobSecurityProfile returns the security profile of a job started by the user, which is the user's profile
+// unless the job asks for a more restrictive one
+func jobSecurityProfile(requested string, user *User) (string, error) {
+	profile := user.SecurityProfile
+	if profile == "" {
+		profile = worker.SecurityProfileDefault
+	}
+
+	if requested == "" {
+		return profile, nil
+	}
+
+	if securityLevel(requested) < 0 {
+		return "", errors.New("Invalid security profile")
+	}
+
+	if securityLevel(requested) < securityLevel(profile) {
+		return "", fmt.Errorf("The security profile cannot be less restrictive than %s", profile)
+	}
+
+	return requested, nil
+}
+
+// securityLevel returns the position of the profile in worker.SecurityProfiles, or -1 if it is unknown
+func securityLevel(profile string) int {
+	for i, p := range worker.SecurityProfiles {
+		if p == profile {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -4,16 +4,23 @@ import (
 	"log"
 
 	"github.com/tmnhat2001/worker-service/internal/api"
+	"github.com/tmnhat2001/worker-service/internal/worker"
 )
 
 const certPath = "certs/server.crt"
 const keyPath = "certs/server.key"
+const dataDir = "data"
 
 func main() {
 	config := api.ServerConfig{
-		Port:         8080,
-		CertFilePath: certPath,
-		KeyFilePath:  keyPath,
+		Port:              8080,
+		CertFilePath:      certPath,
+		KeyFilePath:       keyPath,
+		DataDir:           dataDir,
+		OutputMemoryLimit: 16 << 20,
+		OutputHardLimit:   1 << 30,
+		OutputLimitPolicy: worker.OutputPolicyTruncate,
+		JobStore:          api.JobStoreFile,
 	}
 	server, err := api.NewServer(config)
 	if err != nil {
@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	cgroupMountPoint = "/sys/fs/cgroup"
+	cgroupParentName = "worker-service"
+
+	cgroupRemoveTimeout  = 5 * time.Second
+	cgroupRemoveInterval = 10 * time.Millisecond
+)
+
+// ErrCgroupUnavailable represents an error returned when resource limits are requested but cgroup v2 is not available
+var ErrCgroupUnavailable = errors.New("worker: cgroup v2 is not available on this host")
+
+// ResourceLimits contains the cgroup v2 limits of a Job. Each value is written as-is to the
+// interface file of the same name in the job's cgroup, e.g. CPUMax "50000 100000" or MemoryMax "512M".
+// Empty values keep the kernel defaults.
+type ResourceLimits struct {
+	CPUMax    string
+	MemoryMax string
+	IOMax     string
+	PidsMax   string
+}
+
+func (limits ResourceLimits) isEmpty() bool {
+	return limits == ResourceLimits{}
+}
+
+func (limits ResourceLimits) files() map[string]string {
+	return map[string]string{
+		"cpu.max":    limits.CPUMax,
+		"memory.max": limits.MemoryMax,
+		"io.max":     limits.IOMax,
+		"pids.max":   limits.PidsMax,
+	}
+}
+
+// cgroup is the cgroup v2 subtree created for a single job
+type cgroup struct {
+	path string
+}
+
+// newCgroup creates a cgroup for the job and applies the limits to it.
+// Returns nil without an error if there are no limits to apply.
+func newCgroup(jobID string, limits ResourceLimits) (*cgroup, error) {
+	if limits.isEmpty() {
+		return nil, nil
+	}
+
+	if !cgroupV2Available() {
+		return nil, ErrCgroupUnavailable
+	}
+
+	parentPath := filepath.Join(cgroupMountPoint, cgroupParentName)
+	err := os.MkdirAll(parentPath, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enableControllers(cgroupMountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	err = enableControllers(parentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	group := &cgroup{path: cgroupPath(jobID)}
+	err = os.Mkdir(group.path, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range limits.files() {
+		if value == "" {
+			continue
+		}
+
+		err = group.write(name, value)
+		if err != nil {
+			group.remove()
+			return nil, errors.Wrapf(err, "Unable to set %s", name)
+		}
+	}
+
+	return group, nil
+}
+
+// findCgroup returns the cgroup of the job, or nil if the job has no cgroup
+func findCgroup(jobID string) *cgroup {
+	path := cgroupPath(jobID)
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+
+	return &cgroup{path: path}
+}
+
+func cgroupPath(jobID string) string {
+	return filepath.Join(cgroupMountPoint, cgroupParentName, jobID)
+}
+
+// addProcess moves the process with the given pid into the cgroup
+func (group *cgroup) addProcess(pid int) error {
+	return group.write("cgroup.procs", strconv.Itoa(pid))
+}
+
+// remove kills the processes left in the cgroup and deletes it
+func (group *cgroup) remove() error {
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+
+	for {
+		pids, err := group.processes()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		for _, pid := range pids {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+
+		err = os.Remove(group.path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return err
+		}
+
+		time.Sleep(cgroupRemoveInterval)
+	}
+}
+
+// processes returns the pids of the processes in the cgroup
+func (group *cgroup) processes() ([]int, error) {
+	content, err := ioutil.ReadFile(filepath.Join(group.path, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, field := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+func (group *cgroup) write(name, value string) error {
+	return ioutil.WriteFile(filepath.Join(group.path, name), []byte(value), 0644)
+}
+
+func cgroupV2Available() bool {
+	_, err := os.Stat(filepath.Join(cgroupMountPoint, "cgroup.controllers"))
+	return err == nil
+}
+
+// enableControllers delegates the controllers needed for the limits to the children of the given cgroup
+func enableControllers(path string) error {
+	content, err := ioutil.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+
+	available := make(map[string]bool)
+	for _, controller := range strings.Fields(string(content)) {
+		available[controller] = true
+	}
+
+	var controllers []string
+	for _, controller := range []string{"cpu", "memory", "io", "pids"} {
+		if available[controller] {
+			controllers = append(controllers, "+"+controller)
+		}
+	}
+
+	if len(controllers) == 0 {
+		return nil
+	}
+
+	return ioutil.WriteFile(filepath.Join(path, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644)
+}
@@ -0,0 +1,251 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// childProcessName is the argv[0] used when the worker re-executes its own binary to set up a
+// job's process before running the job's command. Any binary importing this package can act as the child.
+const childProcessName = "worker-child"
+
+const (
+	childConfigFd = 3
+	childErrorFd  = 4
+	childStatusFd = 5
+)
+
+// childConfig is sent by the parent to the child through a pipe once the child is ready to run the command
+type childConfig struct {
+	Path            string
+	Args            []string
+	Dir             string
+	Env             []string
+	Isolated        bool
+	Hostname        string
+	Credential      *syscall.Credential
+	SecurityProfile string
+}
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == childProcessName {
+		runChild()
+	}
+}
+
+// runChild waits for the parent to send the command, then replaces the current process with it.
+// The child of an isolated job runs the command in a new child instead, see runInit.
+// Errors are reported to the parent through the error pipe, which is closed on a successful exec.
+func runChild() {
+	// The credential is switched for the thread making the exec
+	runtime.LockOSThread()
+
+	errorPipe := os.NewFile(childErrorFd, "error")
+	syscall.CloseOnExec(childErrorFd)
+
+	configPipe := os.NewFile(childConfigFd, "config")
+	var config childConfig
+	err := json.NewDecoder(configPipe).Decode(&config)
+	configPipe.Close()
+	if err != nil {
+		exitChild(errorPipe, errors.Wrap(err, "Unable to read child config"))
+	}
+
+	if config.Isolated {
+		err = setupNamespaces(config.Hostname)
+		if err != nil {
+			exitChild(errorPipe, err)
+		}
+
+		runInit(config, errorPipe)
+	}
+
+	// The command does not inherit the capabilities the child has been given
+	err = dropInheritableCaps()
+	if err != nil {
+		exitChild(errorPipe, err)
+	}
+
+	err = dropCapabilities(config.SecurityProfile)
+	if err != nil {
+		exitChild(errorPipe, err)
+	}
+
+	if config.Credential != nil {
+		err = switchCredential(config.Credential)
+		if err != nil {
+			exitChild(errorPipe, err)
+		}
+	}
+
+	// The account of the job must be able to access its working directory
+	if config.Dir != "" {
+		err = syscall.Chdir(config.Dir)
+		if err != nil {
+			exitChild(errorPipe, errors.Errorf("%s: %v", ErrInvalidWorkDir, err))
+		}
+	}
+
+	err = restrictSyscalls(config.SecurityProfile)
+	if err != nil {
+		exitChild(errorPipe, err)
+	}
+
+	err = syscall.Exec(config.Path, config.Args, config.Env)
+	exitChild(errorPipe, errors.Wrap(err, "Unable to run command"))
+}
+
+func exitChild(errorPipe *os.File, err error) {
+	fmt.Fprint(errorPipe, err.Error())
+	os.Exit(1)
+}
+
+// childProcess is a job process started through the child, which lets the parent act on the
+// process (e.g. move it to a cgroup) before the command is run. The init of an isolated job
+// reports how the command exited through status.
+type childProcess struct {
+	cmd    *exec.Cmd
+	config childConfig
+	status *os.File
+}
+
+func newChildProcess(cmd *exec.Cmd) *childProcess {
+	// The child runs with the server's environment, since it may run as root: the job's environment,
+	// such as LD_PRELOAD, only applies to the command
+	child := &exec.Cmd{
+		Path:   "/proc/self/exe",
+		Args:   []string{childProcessName},
+		Stdin:  cmd.Stdin,
+		Stdout: cmd.Stdout,
+		Stderr: cmd.Stderr,
+	}
+
+	config := childConfig{Path: cmd.Path, Args: cmd.Args, Dir: cmd.Dir, Env: cmd.Env}
+	if cmd.SysProcAttr != nil {
+		// The child switches to the credential itself, after setting up the namespaces of the job
+		sysProcAttr := *cmd.SysProcAttr
+		config.Credential = sysProcAttr.Credential
+		sysProcAttr.Credential = nil
+		child.SysProcAttr = &sysProcAttr
+
+		// In the user namespace of an unprivileged server, the child is not root and keeps the capabilities
+		// it needs across its exec as ambient capabilities
+		if sysProcAttr.Cloneflags&syscall.CLONE_NEWUSER != 0 {
+			sysProcAttr.AmbientCaps = []uintptr{capSetpcap}
+		}
+	}
+
+	return &childProcess{
+		cmd:    child,
+		config: config,
+	}
+}
+
+// isolate makes the child run the command in new namespaces
+func (child *childProcess) isolate(hostname string) {
+	if child.cmd.SysProcAttr == nil {
+		child.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	child.cmd.SysProcAttr.Cloneflags |= namespaceCloneFlags
+	if child.cmd.SysProcAttr.Cloneflags&syscall.CLONE_NEWUSER != 0 {
+		child.cmd.SysProcAttr.AmbientCaps = append(child.cmd.SysProcAttr.AmbientCaps, capSysAdmin, capNetAdmin)
+	}
+	child.config.Isolated = true
+	child.config.Hostname = hostname
+}
+
+// start starts the child, calls setup with its pid, then lets the child run the command.
+// Returns an error if setup fails or the command cannot be run.
+func (child *childProcess) start(setup func(pid int) error) error {
+	configReader, configWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer configWriter.Close()
+
+	errorReader, errorWriter, err := os.Pipe()
+	if err != nil {
+		configReader.Close()
+		return err
+	}
+	defer errorReader.Close()
+
+	child.cmd.ExtraFiles = []*os.File{configReader, errorWriter}
+	var statusWriter *os.File
+	if child.config.Isolated {
+		child.status, statusWriter, err = os.Pipe()
+		if err != nil {
+			configReader.Close()
+			errorWriter.Close()
+			return err
+		}
+
+		child.cmd.ExtraFiles = append(child.cmd.ExtraFiles, statusWriter)
+	}
+
+	err = child.cmd.Start()
+	configReader.Close()
+	errorWriter.Close()
+	if statusWriter != nil {
+		statusWriter.Close()
+	}
+	if err != nil {
+		child.closeStatus()
+		return err
+	}
+
+	err = setup(child.cmd.Process.Pid)
+	if err != nil {
+		child.kill()
+		return err
+	}
+
+	err = json.NewEncoder(configWriter).Encode(child.config)
+	configWriter.Close()
+	if err != nil {
+		child.kill()
+		return err
+	}
+
+	childError, err := ioutil.ReadAll(errorReader)
+	if err != nil {
+		child.kill()
+		return err
+	}
+
+	if len(childError) > 0 {
+		child.cmd.Wait()
+		child.closeStatus()
+
+		message := string(childError)
+		if strings.HasPrefix(message, ErrInvalidWorkDir.Error()+": ") {
+			return errors.WithMessage(ErrInvalidWorkDir, strings.TrimPrefix(message, ErrInvalidWorkDir.Error()+": "))
+		}
+
+		return errors.New(message)
+	}
+
+	return nil
+}
+
+func (child *childProcess) kill() {
+	child.cmd.Process.Kill()
+	child.cmd.Wait()
+	child.closeStatus()
+}
+
+func (child *childProcess) closeStatus() {
+	if child.status != nil {
+		child.status.Close()
+		child.status = nil
+	}
+}
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// The following constants are the capabilities and prctl options missing from the syscall package
+const (
+	capSetpcap           = 8
+	capNetAdmin          = 12
+	capSysAdmin          = 21
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+
+	linuxCapabilityVersion3 = 0x20080522
+)
+
+// Credential is the Unix account the process of a job runs as: its user ID, group ID and supplementary groups
+type Credential struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+}
+
+// setCredential makes the command run as the job's Credential, if it has one. A server running as root
+// switches to the account when starting the process. An unprivileged server cannot, so the process runs
+// in a new user namespace where the account is mapped to the server's own user and group: the process has
+// no more privilege than the server on the host, and cannot set its supplementary groups.
+func (job *Job) setCredential(cmd *exec.Cmd) {
+	if job.Credential == nil {
+		return
+	}
+
+	credential := &syscall.Credential{Uid: job.Credential.UID, Gid: job.Credential.GID, Groups: job.Credential.Groups}
+	if os.Geteuid() != 0 {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: int(credential.Uid), HostID: os.Geteuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: int(credential.Gid), HostID: os.Getegid(), Size: 1}}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+		credential.Groups = nil
+		credential.NoSetGroups = true
+	}
+
+	cmd.SysProcAttr.Credential = credential
+}
+
+// switchCredential runs in the child, once it is ready to run the command. The credential only applies to
+// the current thread, which must be locked and make the exec.
+func switchCredential(credential *syscall.Credential) error {
+	if !credential.NoSetGroups {
+		groups := make([]uint32, len(credential.Groups))
+		copy(groups, credential.Groups)
+
+		var groupsPointer unsafe.Pointer
+		if len(groups) > 0 {
+			groupsPointer = unsafe.Pointer(&groups[0])
+		}
+
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, uintptr(len(groups)), uintptr(groupsPointer), 0)
+		runtime.KeepAlive(groups)
+		if errno != 0 {
+			return errors.Wrap(errno, "Unable to set supplementary groups")
+		}
+	}
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SETGID, uintptr(credential.Gid), 0, 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "Unable to set group ID")
+	}
+
+	_, _, errno = syscall.RawSyscall(syscall.SYS_SETUID, uintptr(credential.Uid), 0, 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "Unable to set user ID")
+	}
+
+	return nil
+}
+
+// capUserHeader and capUserData mirror the structs of the capget and capset system calls
+type capUserHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capUserData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// dropInheritableCaps clears the ambient and inheritable capabilities of the current thread, so that
+// a process which is not root does not keep or regain any capability across an exec
+func dropInheritableCaps() error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0)
+	if errno != 0 && errno != syscall.EINVAL {
+		return errors.Wrap(errno, "Unable to clear ambient capabilities")
+	}
+
+	header := capUserHeader{version: linuxCapabilityVersion3}
+	var data [2]capUserData
+	_, _, errno = syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "Unable to get capabilities")
+	}
+
+	data[0].inheritable, data[1].inheritable = 0, 0
+	_, _, errno = syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "Unable to clear inheritable capabilities")
+	}
+
+	return nil
+}
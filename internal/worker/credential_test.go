@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Switching to another account requires root")
+	}
+
+	store := NewMemoryJobStore()
+	credential := &Credential{UID: 65534, GID: 65534, Groups: []uint32{65533}}
+
+	// The child switches to the credential after setting up the namespaces
+	for _, isolation := range []string{IsolationNone, IsolationNamespaces} {
+		job := &Job{Args: []string{"sh", "-c", "id -u; id -g; id -G"}, Credential: credential, Isolation: isolation}
+		err := job.Start(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := waitForFinalStatus(store, job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.Status != Completed || found.Stdout != "65534\n65534\n65534 65533\n" {
+			t.Errorf("Expected the %s job to run as the credential, but got %s with output %q, %q", isolation, found.Status, found.Stdout, found.Stderr)
+		}
+
+		if found.Credential == nil || found.Credential.UID != 65534 {
+			t.Errorf("Expected the job to record its credential, but got %+v", found.Credential)
+		}
+	}
+}
+
+func TestCredentialWorkDir(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Switching to another account requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "worker-workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewMemoryJobStore()
+	credential := &Credential{UID: 65534, GID: 65534}
+
+	// The directory is only accessible to root, which the server runs as
+	for _, profile := range []string{SecurityProfileUnconfined, SecurityProfileDefault} {
+		job := &Job{Args: []string{"pwd"}, WorkDir: dir, Credential: credential, SecurityProfile: profile}
+		err = job.Start(store)
+		if !errors.Is(err, ErrInvalidWorkDir) {
+			t.Errorf("Expected ErrInvalidWorkDir under the %s profile, but got %v", profile, err)
+		}
+
+		job = &Job{Args: []string{"pwd"}, WorkDir: dir, SecurityProfile: profile}
+		err = job.Start(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := waitForFinalStatus(store, job.ID)
+		if err != nil || found.Stdout != dir+"\n" {
+			t.Errorf("Expected the job of the server's account to run in %s, but got %q, %v", dir, found.Stdout, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package worker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultEventHistorySize is the number of events of each user kept by the EventBus of a job store,
+// so that a subscriber can resume after reconnecting
+const DefaultEventHistorySize = 1000
+
+// subscriptionBufferSize is the number of events a subscriber can be late by before it is dropped
+const subscriptionBufferSize = 256
+
+// The following constants are possible values for the Type of an Event
+const (
+	EventJobCreated       = "job_created"
+	EventJobStatusChanged = "job_status_changed"
+	EventJobSignaled      = "job_signaled"
+)
+
+// Event is a change of a job published by its JobStore. An event is published when a job is added
+// to the store, and each time it changes status, from From to Status. The exit of the job's process
+// is described once it has a final status. IDs are increasing, including across restarts of the server.
+type Event struct {
+	ID           uint64
+	Type         string
+	Time         time.Time
+	JobID        string
+	User         string
+	From         JobState `json:",omitempty"`
+	Status       JobState
+	StatusReason string     `json:",omitempty"`
+	ExitReason   ExitReason `json:",omitempty"`
+	ExitCode     *int       `json:",omitempty"`
+	// Signal is the name of the signal that terminated the job's process, or of the signal sent to the job
+	// with SendSignal for a job_signaled event, whose Status is the current status of the job
+	Signal string `json:",omitempty"`
+}
+
+// EventBus publishes the events of the jobs to its subscribers, and keeps the latest events of each user
+// in a history, so that the jobs of a user cannot push the events of another user out of it.
+// Publishing never blocks: a subscriber which does not keep up with the events is dropped.
+type EventBus struct {
+	mutex       sync.Mutex
+	firstID     uint64
+	lastID      uint64
+	histories   map[string]*eventHistory
+	historySize int
+	subscribers map[*Subscription]bool
+}
+
+// eventHistory is the history of the events of a user
+type eventHistory struct {
+	events []Event
+	// evicted is the ID of the latest event removed from the history
+	evicted uint64
+}
+
+// Subscription receives the events published on an EventBus. Events is closed when the subscription
+// is closed, or when the subscriber has been dropped because it did not keep up with the events.
+type Subscription struct {
+	Events <-chan Event
+
+	bus     *EventBus
+	user    string
+	events  chan Event
+	dropped bool
+}
+
+// NewEventBus returns an EventBus which keeps the given number of events of each user in its history.
+// The IDs of its events start after the current time in microseconds, so that they are greater than
+// the IDs of the events of a previous server, while remaining exact as JavaScript numbers.
+func NewEventBus(historySize int) *EventBus {
+	firstID := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	return &EventBus{
+		firstID:     firstID,
+		lastID:      firstID,
+		histories:   make(map[string]*eventHistory),
+		historySize: historySize,
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// publish assigns the next ID to the event and sends it to the subscribers
+func (bus *EventBus) publish(event Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.lastID++
+	event.ID = bus.lastID
+	event.Time = time.Now().UTC()
+
+	history, ok := bus.histories[event.User]
+	if !ok {
+		history = &eventHistory{}
+		bus.histories[event.User] = history
+	}
+
+	history.events = append(history.events, event)
+	if len(history.events) > bus.historySize {
+		evicted := len(history.events) - bus.historySize
+		history.evicted = history.events[evicted-1].ID
+		history.events = append(history.events[:0:0], history.events[evicted:]...)
+	}
+
+	for subscription := range bus.subscribers {
+		if subscription.user != "" && subscription.user != event.User {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			subscription.dropped = true
+			bus.unsubscribe(subscription)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events of the user's jobs published from now on, or to the
+// events of all the jobs if user is empty. The events published after lastEventID which are still in
+// the history are also returned, and complete is false if some of them are no longer in it, or if
+// lastEventID was not published by this bus. A zero lastEventID only subscribes to the next events.
+func (bus *EventBus) Subscribe(user string, lastEventID uint64) (subscription *Subscription, missed []Event, complete bool) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	events := make(chan Event, subscriptionBufferSize)
+	subscription = &Subscription{Events: events, bus: bus, user: user, events: events}
+	bus.subscribers[subscription] = true
+
+	if lastEventID == 0 {
+		return subscription, nil, true
+	}
+
+	complete = lastEventID >= bus.firstID && lastEventID <= bus.lastID
+	for historyUser, history := range bus.histories {
+		if user != "" && historyUser != user {
+			continue
+		}
+
+		if lastEventID < history.evicted {
+			complete = false
+		}
+
+		for _, event := range history.events {
+			if event.ID > lastEventID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	sort.Slice(missed, func(i, j int) bool { return missed[i].ID < missed[j].ID })
+	return subscription, missed, complete
+}
+
+// unsubscribe removes the subscription. The mutex must be held.
+func (bus *EventBus) unsubscribe(subscription *Subscription) {
+	if bus.subscribers[subscription] {
+		delete(bus.subscribers, subscription)
+		close(subscription.events)
+	}
+}
+
+// Close stops the subscription and closes its Events
+func (subscription *Subscription) Close() {
+	subscription.bus.mutex.Lock()
+	defer subscription.bus.mutex.Unlock()
+
+	subscription.bus.unsubscribe(subscription)
+}
+
+// Dropped returns true if the subscription has been closed because the subscriber did not keep up with the events
+func (subscription *Subscription) Dropped() bool {
+	subscription.bus.mutex.Lock()
+	defer subscription.bus.mutex.Unlock()
+
+	return subscription.dropped
+}
+
+// jobEvent returns the event of the given type describing the job
+func jobEvent(eventType string, job Job, from JobState) Event {
+	event := Event{
+		Type:         eventType,
+		JobID:        job.ID,
+		User:         job.User,
+		From:         from,
+		Status:       job.Status,
+		StatusReason: job.StatusReason,
+	}
+
+	if job.Status.Final() {
+		event.ExitReason = job.ExitReason
+		event.ExitCode = job.ExitCode
+		event.Signal = job.Signal
+	}
+
+	return event
+}
@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus(3)
+	first, _, _ := bus.Subscribe("", 0)
+	defer first.Close()
+
+	// The events of a previous server have smaller IDs
+	_, missed, complete := bus.Subscribe("", bus.lastID-1)
+	if complete || len(missed) != 0 {
+		t.Errorf("Expected an event of a previous server to be incomplete, but got %v, complete: %t", missed, complete)
+	}
+
+	var ids []uint64
+	for i := 0; i < 5; i++ {
+		bus.publish(Event{Type: EventJobCreated, JobID: "job"})
+	}
+
+	for i := 0; i < 5; i++ {
+		event := <-first.Events
+		if i > 0 && event.ID != ids[i-1]+1 {
+			t.Errorf("Expected event %d, but got %d", ids[i-1]+1, event.ID)
+		}
+		ids = append(ids, event.ID)
+	}
+
+	// The last 3 events are still in the history
+	second, missed, complete := bus.Subscribe("", ids[1])
+	second.Close()
+	if !complete || len(missed) != 3 || missed[0].ID != ids[2] {
+		t.Errorf("Expected to resume from event %d, but got %v, complete: %t", ids[2], missed, complete)
+	}
+
+	_, missed, complete = bus.Subscribe("", ids[0])
+	if complete || len(missed) != 3 {
+		t.Errorf("Expected event %d to be missing from the history, but got %v, complete: %t", ids[1], missed, complete)
+	}
+
+	_, missed, complete = bus.Subscribe("", ids[4]+1)
+	if complete || len(missed) != 0 {
+		t.Errorf("Expected an unknown event to be incomplete, but got %v, complete: %t", missed, complete)
+	}
+
+	// A subscriber which does not read its events is dropped instead of blocking the publisher
+	slow, _, _ := bus.Subscribe("", 0)
+	for i := 0; i < subscriptionBufferSize+1; i++ {
+		bus.publish(Event{Type: EventJobCreated, JobID: "job"})
+	}
+
+	received := 0
+	for range slow.Events {
+		received++
+	}
+
+	if !slow.Dropped() || received != subscriptionBufferSize {
+		t.Errorf("Expected the slow subscriber to be dropped after %d events, but got %d, dropped: %t", subscriptionBufferSize, received, slow.Dropped())
+	}
+}
+
+func TestEventBusUsers(t *testing.T) {
+	bus := NewEventBus(3)
+	subscription, _, _ := bus.Subscribe("user1", 0)
+	defer subscription.Close()
+
+	bus.publish(Event{Type: EventJobCreated, JobID: "job1", User: "user1"})
+	event := <-subscription.Events
+	lastEventID := event.ID
+
+	// The events of another user neither reach the subscriber nor push the user's events out of the history
+	for i := 0; i < 5; i++ {
+		bus.publish(Event{Type: EventJobCreated, JobID: "job2", User: "user2"})
+	}
+	bus.publish(Event{Type: EventJobStatusChanged, JobID: "job1", User: "user1"})
+
+	event = <-subscription.Events
+	if event.JobID != "job1" || event.Type != EventJobStatusChanged {
+		t.Errorf("Expected only the events of user1, but got %+v", event)
+	}
+
+	_, missed, complete := bus.Subscribe("user1", lastEventID-1)
+	if !complete || len(missed) != 2 || missed[0].ID != lastEventID {
+		t.Errorf("Expected the 2 events of user1 to be in the history, but got %v, complete: %t", missed, complete)
+	}
+
+	_, missed, complete = bus.Subscribe("user2", lastEventID)
+	if complete || len(missed) != 3 {
+		t.Errorf("Expected the first events of user2 to be missing from the history, but got %v, complete: %t", missed, complete)
+	}
+}
+
+func TestJobStoreEvents(t *testing.T) {
+	store := NewMemoryJobStore()
+	subscription, _, _ := store.Events().Subscribe("", 0)
+	defer subscription.Close()
+
+	store.AddJob(&Job{ID: "job", User: "user1", Status: Pending})
+	store.UpdateJob("job", Pending, JobUpdate{Status: Running})
+	store.UpdateJob("job", Pending, JobUpdate{Status: Errored})
+	exitCode := 0
+	store.UpdateJob("job", Running, JobUpdate{Status: Completed, ExitReason: ExitReasonExited, ExitCode: &exitCode})
+
+	expected := []Event{
+		{Type: EventJobCreated, Status: Pending},
+		{Type: EventJobStatusChanged, From: Pending, Status: Running},
+		{Type: EventJobStatusChanged, From: Running, Status: Completed, ExitReason: ExitReasonExited},
+	}
+	for _, expectedEvent := range expected {
+		event := <-subscription.Events
+		if event.Type != expectedEvent.Type || event.From != expectedEvent.From || event.Status != expectedEvent.Status ||
+			event.ExitReason != expectedEvent.ExitReason || event.JobID != "job" || event.User != "user1" {
+			t.Errorf("Expected event %+v, but got %+v", expectedEvent, event)
+		}
+	}
+
+	select {
+	case event := <-subscription.Events:
+		t.Errorf("Expected the rejected update not to be published, but got %+v", event)
+	default:
+	}
+}
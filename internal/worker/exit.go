@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"syscall"
+)
+
+// ExitReason explains how the process of a finished job ended
+type ExitReason string
+
+// The following constants are possible values for the ExitReason of a Job
+const (
+	ExitReasonExited      ExitReason = "exited"
+	ExitReasonSignaled    ExitReason = "signaled"
+	ExitReasonOOMKilled   ExitReason = "oom_killed"
+	ExitReasonTimedOut    ExitReason = "timed_out"
+	ExitReasonStartFailed ExitReason = "start_failed"
+)
+
+// exitUpdate returns the update of the job describing how its process has exited
+func exitUpdate(status syscall.WaitStatus) JobUpdate {
+	if status.Signaled() {
+		return JobUpdate{
+			ExitReason: ExitReasonSignaled,
+			Signal:     terminationSignal(status),
+			CoreDumped: status.CoreDump(),
+		}
+	}
+
+	exitCode := status.ExitStatus()
+	return JobUpdate{ExitReason: ExitReasonExited, ExitCode: &exitCode}
+}
+
+// oomKilled returns true if the kernel has killed a process of the cgroup because it ran out of memory
+func (group *cgroup) oomKilled() bool {
+	return group.readStats("memory.events")["oom_kill"] > 0
+}
@@ -0,0 +1,504 @@
+package worker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// storeSchemaVersion is the version of the records written by FileJobStore. When the format of the
+// stored jobs changes, the version is incremented and a migration from the previous version is
+// added to storeMigrations.
+const storeSchemaVersion = 2
+
+// snapshotInterval is the number of journal records after which the journal is compacted into the snapshot
+const snapshotInterval = 1000
+
+const (
+	snapshotFileName = "snapshot.json"
+	journalFileName  = "journal.log"
+)
+
+// recordHeaderSize is the size of the header of a journal record: the length and the CRC-32 of its payload
+const recordHeaderSize = 8
+
+// maxRecordSize is the largest payload of a journal record. Larger lengths can only come from a corrupted journal.
+const maxRecordSize = 64 << 20
+
+// storeMigrations converts a stored job from the version of its key to the next version
+var storeMigrations = map[int]func(json.RawMessage) (json.RawMessage, error){
+	1: migrateExitCode,
+}
+
+// ErrUnsupportedSchema represents an error returned when the files of a FileJobStore were written
+// by a newer version of the server
+var ErrUnsupportedSchema = errors.New("worker: Unsupported job store schema version")
+
+// FileJobStore implements the JobStore interface. It keeps the Jobs in memory like MemoryJobStore,
+// and also records them in its directory so that they survive restarts of the server.
+// Every update of a job appends the whole job to a journal, which is synced before the update is applied.
+// The journal is regularly compacted into a snapshot, replaced atomically. A record partially written
+// by a crash is detected by its checksum and discarded when the store is opened.
+// The output of the jobs is only kept if it was written to files, see OutputLimits.Persist.
+type FileJobStore struct {
+	*MemoryJobStore
+
+	dir     string
+	mutex   sync.Mutex
+	journal *os.File
+	records int
+}
+
+// storedJob is a Job as it is recorded in the files of a FileJobStore.
+// It includes the fields of the job which are not part of its JSON representation.
+type storedJob struct {
+	Job
+	Pid          int
+	OutputLimits OutputLimits
+}
+
+// storeRecord is a journal record, or the snapshot when it contains all the jobs
+type storeRecord struct {
+	Version int
+	Jobs    []json.RawMessage
+}
+
+// OpenFileJobStore returns a FileJobStore containing the jobs previously recorded in dir
+func OpenFileJobStore(dir string) (*FileJobStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create job store directory")
+	}
+
+	store := &FileJobStore{MemoryJobStore: NewMemoryJobStore(), dir: dir}
+	err = store.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.loadJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// AddJob adds a Job to the store and records it
+func (store *FileJobStore) AddJob(job *Job) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.MemoryJobStore.AddJob(job)
+	err := store.record(job.ID)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// UpdateJob atomically updates a Job in the store like MemoryJobStore.UpdateJob, and records it
+func (store *FileJobStore) UpdateJob(jobID string, from JobState, update JobUpdate) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// The update is recorded before it is applied, so that an update which is not in the journal is
+	// neither seen by the clients nor published. The updates of the store are serialized by its mutex,
+	// so the job cannot change between the two steps.
+	store.MemoryJobStore.mutex.RLock()
+	job, err := store.MemoryJobStore.updatedJob(jobID, from, update)
+	output := store.outputs[jobID]
+	store.MemoryJobStore.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	data, err := encodeJob(job, output)
+	if err != nil {
+		return err
+	}
+
+	err = store.appendRecord(data)
+	if err != nil {
+		return err
+	}
+
+	store.MemoryJobStore.mutex.Lock()
+	store.MemoryJobStore.replaceJob(job, from)
+	store.MemoryJobStore.mutex.Unlock()
+
+	return store.compact()
+}
+
+// RecoverJobs reconciles the jobs that were running when the previous server stopped. Each of them
+// is either re-adopted if its process is still running, or marked as Lost. The jobs which were still
+// Pending are Lost, since their process may have started without being recorded.
+func (store *FileJobStore) RecoverJobs() []Recovery {
+	var jobs []Job
+	store.MemoryJobStore.mutex.RLock()
+	for _, job := range store.Jobs {
+		if !job.Status.Final() {
+			jobs = append(jobs, job)
+		}
+	}
+	store.MemoryJobStore.mutex.RUnlock()
+
+	recoveries := make([]Recovery, 0, len(jobs))
+	for i := range jobs {
+		recoveries = append(recoveries, jobs[i].recover(store))
+	}
+
+	return recoveries
+}
+
+// Close closes the journal. The store cannot be changed once closed.
+func (store *FileJobStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.journal.Close()
+}
+
+// record appends the current state of the job to the journal
+func (store *FileJobStore) record(jobID string) error {
+	job, err := store.storedJob(jobID)
+	if err != nil {
+		return err
+	}
+
+	err = store.appendRecord(job)
+	if err != nil {
+		return err
+	}
+
+	return store.compact()
+}
+
+// appendRecord appends the encoded job to the journal, and syncs it
+func (store *FileJobStore) appendRecord(job json.RawMessage) error {
+	payload, err := json.Marshal(storeRecord{Version: storeSchemaVersion, Jobs: []json.RawMessage{job}})
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode job")
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	_, err = store.journal.Write(record)
+	if err == nil {
+		err = store.journal.Sync()
+	}
+	if err != nil {
+		return errors.Wrap(err, "Unable to write job store journal")
+	}
+
+	store.records++
+	return nil
+}
+
+// compact writes the snapshot once the journal has snapshotInterval records
+func (store *FileJobStore) compact() error {
+	if store.records >= snapshotInterval {
+		return store.writeSnapshot()
+	}
+
+	return nil
+}
+
+func (store *FileJobStore) storedJob(jobID string) (json.RawMessage, error) {
+	store.MemoryJobStore.mutex.RLock()
+	job, ok := store.Jobs[jobID]
+	output := store.outputs[jobID]
+	store.MemoryJobStore.mutex.RUnlock()
+
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	return encodeJob(job, output)
+}
+
+// encodeJob returns the job as it is recorded, with the size of its output
+func encodeJob(job Job, output *JobOutput) (json.RawMessage, error) {
+	job.OutputBytes = output.TotalBytes()
+	job.OutputTruncated = output.Truncated()
+	data, err := json.Marshal(storedJob{Job: job, Pid: job.Pid, OutputLimits: job.OutputLimits})
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to encode job")
+	}
+
+	return data, nil
+}
+
+// writeSnapshot replaces the snapshot with all the jobs of the store, then empties the journal.
+// The journal's records are already in the new snapshot if the server crashes before the journal
+// is emptied: replaying them is harmless since each record contains the whole job.
+func (store *FileJobStore) writeSnapshot() error {
+	store.MemoryJobStore.mutex.RLock()
+	jobIDs := make([]string, 0, len(store.Jobs))
+	for id := range store.Jobs {
+		jobIDs = append(jobIDs, id)
+	}
+	store.MemoryJobStore.mutex.RUnlock()
+
+	snapshot := storeRecord{Version: storeSchemaVersion}
+	for _, id := range jobIDs {
+		job, err := store.storedJob(id)
+		if err != nil {
+			return err
+		}
+
+		snapshot.Jobs = append(snapshot.Jobs, job)
+	}
+
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode job store snapshot")
+	}
+
+	err = writeFileAtomically(filepath.Join(store.dir, snapshotFileName), content)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write job store snapshot")
+	}
+
+	err = store.journal.Truncate(0)
+	if err == nil {
+		_, err = store.journal.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = store.journal.Sync()
+	}
+	if err != nil {
+		return errors.Wrap(err, "Unable to empty job store journal")
+	}
+
+	store.records = 0
+	return nil
+}
+
+func (store *FileJobStore) loadSnapshot() error {
+	content, err := ioutil.ReadFile(filepath.Join(store.dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "Unable to read job store snapshot")
+	}
+
+	var snapshot storeRecord
+	err = json.Unmarshal(content, &snapshot)
+	if err != nil {
+		return errors.Wrap(err, "Unable to decode job store snapshot")
+	}
+
+	return store.load(snapshot)
+}
+
+// loadJournal replays the journal and opens it for writing. The records following the first
+// incomplete or corrupted one were never acknowledged, so they are truncated.
+func (store *FileJobStore) loadJournal() error {
+	journal, err := os.OpenFile(filepath.Join(store.dir, journalFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to open job store journal")
+	}
+
+	var valid int64
+	reader := bufio.NewReader(journal)
+	for {
+		payload, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Discarding the end of the job store journal after %d bytes: %v", valid, err)
+			break
+		}
+
+		var record storeRecord
+		err = json.Unmarshal(payload, &record)
+		if err != nil {
+			journal.Close()
+			return errors.Wrap(err, "Unable to decode job store journal")
+		}
+
+		err = store.load(record)
+		if err != nil {
+			journal.Close()
+			return err
+		}
+
+		valid += int64(recordHeaderSize + len(payload))
+		store.records++
+	}
+
+	err = journal.Truncate(valid)
+	if err == nil {
+		_, err = journal.Seek(valid, io.SeekStart)
+	}
+	if err != nil {
+		journal.Close()
+		return errors.Wrap(err, "Unable to truncate job store journal")
+	}
+
+	store.journal = journal
+	return nil
+}
+
+// readRecord returns the payload of the next journal record, or io.EOF at the end of the journal
+func readRecord(reader io.Reader) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	_, err := io.ReadFull(reader, header)
+	if err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Incomplete record header")
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return nil, errors.Errorf("Invalid record length %d", length)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "Incomplete record")
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, errors.New("Invalid record checksum")
+	}
+
+	return payload, nil
+}
+
+// load adds the jobs of a record to the memory store, replacing their previous state
+func (store *FileJobStore) load(record storeRecord) error {
+	if record.Version > storeSchemaVersion {
+		return errors.Wrapf(ErrUnsupportedSchema, "Version %d", record.Version)
+	}
+
+	for _, data := range record.Jobs {
+		data, err := migrateJob(data, record.Version)
+		if err != nil {
+			return err
+		}
+
+		var stored storedJob
+		err = json.Unmarshal(data, &stored)
+		if err != nil {
+			return errors.Wrap(err, "Unable to decode stored job")
+		}
+
+		job := stored.Job
+		job.Pid = stored.Pid
+		job.OutputLimits = stored.OutputLimits
+		job.output = openJobOutput(job.ID, job.OutputLimits, job.OutputBytes, job.OutputTruncated)
+
+		// The jobs of the previous server are not published as new jobs
+		store.MemoryJobStore.mutex.Lock()
+		store.MemoryJobStore.insertJob(&job)
+		store.MemoryJobStore.mutex.Unlock()
+	}
+
+	return nil
+}
+
+func migrateJob(data json.RawMessage, version int) (json.RawMessage, error) {
+	for ; version < storeSchemaVersion; version++ {
+		migrate, ok := storeMigrations[version]
+		if !ok {
+			return nil, errors.Wrapf(ErrUnsupportedSchema, "No migration from version %d", version)
+		}
+
+		var err error
+		data, err = migrate(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to migrate stored job from version %d", version)
+		}
+	}
+
+	return data, nil
+}
+
+// migrateExitCode converts the ExitCode string of version 1, which was "-1" when the process did not
+// exit on its own, to the ExitReason, ExitCode and Signal of version 2
+func migrateExitCode(data json.RawMessage) (json.RawMessage, error) {
+	var job map[string]interface{}
+	err := json.Unmarshal(data, &job)
+	if err != nil {
+		return nil, err
+	}
+
+	exitCode, _ := job["ExitCode"].(string)
+	signal, _ := job["Signal"].(string)
+	delete(job, "ExitCode")
+
+	if exitCode != "" && exitCode != "-1" {
+		code, err := strconv.Atoi(exitCode)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid exit code %q", exitCode)
+		}
+
+		job["ExitCode"] = code
+	}
+
+	switch {
+	case job["Status"] == string(TimedOut):
+		job["ExitReason"] = ExitReasonTimedOut
+	case signal != "":
+		job["ExitReason"] = ExitReasonSignaled
+	case job["ExitCode"] != nil:
+		job["ExitReason"] = ExitReasonExited
+	case job["Status"] == string(Errored) && job["StartedAt"] == nil:
+		job["ExitReason"] = ExitReasonStartFailed
+	}
+
+	return json.Marshal(job)
+}
+
+// writeFileAtomically replaces the file at path with content, so that the file has either
+// its previous or its new content after a crash
+func writeFileAtomically(path string, content []byte) error {
+	tempPath := path + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
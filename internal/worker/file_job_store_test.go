@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestFileJobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenFileJobStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits := OutputLimits{MemoryLimit: 4, Dir: filepath.Join(dir, "output"), Persist: true}
+	job := Job{ID: "job", Pid: 42, Status: Running, Command: "echo hello", User: "user1", OutputLimits: limits}
+	job.output = newJobOutput(job.ID, limits)
+	job.output.Stdout.Write([]byte("hello\n"))
+	job.output.close()
+	store.AddJob(&job)
+
+	err = store.UpdateJob(job.ID, Running, JobUpdate{Status: Stopping})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// A record partially written by a crash
+	journal, err := os.OpenFile(filepath.Join(dir, "store", journalFileName), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.Write([]byte{200, 0, 0, 0, 1, 2})
+	journal.Close()
+
+	store, err = OpenFileJobStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedJob, err := FindJobDetails(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if storedJob.Status != Stopping || storedJob.Pid != 42 || storedJob.User != "user1" {
+		t.Errorf("Incorrect job after reopening the store: %+v", storedJob)
+	}
+
+	if storedJob.Stdout != "hello\n" || storedJob.OutputBytes != 6 {
+		t.Errorf("Incorrect output after reopening the store: %q, %d bytes", storedJob.Stdout, storedJob.OutputBytes)
+	}
+
+	// The incomplete record has been truncated, so new records are readable
+	err = store.UpdateJob(job.ID, Stopping, JobUpdate{Status: Stopped, ExitReason: ExitReasonSignaled, Signal: "SIGTERM"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < snapshotInterval; i++ {
+		store.AddJob(&Job{ID: "job" + string(rune('a'+i%26)), Status: Completed})
+	}
+	store.Close()
+
+	store, err = OpenFileJobStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	storedJob, err = store.FindJob(job.ID)
+	if err != nil || storedJob.Status != Stopped || storedJob.Signal != "SIGTERM" || storedJob.ExitCode != nil {
+		t.Errorf("Expected the job to be stopped after the snapshot, but got %+v, %v", storedJob, err)
+	}
+
+	if store.records >= snapshotInterval {
+		t.Errorf("Expected the journal to be compacted, but it has %d records", store.records)
+	}
+}
+
+func TestFileJobStoreJournalFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenFileJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := Job{ID: "job", Pid: 42, Status: Running, Command: "sleep 30"}
+	store.AddJob(&job)
+	lastEventID := store.Events().lastID
+
+	// The journal can no longer be written
+	store.journal.Close()
+
+	err = store.UpdateJob(job.ID, Running, JobUpdate{Status: Stopping})
+	if err == nil {
+		t.Fatal("Expected the update to fail when the journal cannot be written")
+	}
+
+	storedJob, err := store.FindJob(job.ID)
+	if err != nil || storedJob.Status != Running {
+		t.Errorf("Expected the update not to be applied, but got %+v, %v", storedJob, err)
+	}
+
+	if store.Events().lastID != lastEventID {
+		t.Errorf("Expected the update not to be published, but the last event is %d", store.Events().lastID)
+	}
+
+	store, err = OpenFileJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	storedJob, err = store.FindJob(job.ID)
+	if err != nil || storedJob.Status != Running {
+		t.Errorf("Expected the job to be running after reopening the store, but got %+v, %v", storedJob, err)
+	}
+}
+
+func TestFileJobStoreMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot := `{"Version": 1, "Jobs": [
+		{"ID": "exited", "Status": "errored", "StartedAt": "2021-01-01T00:00:00Z", "ExitCode": "3"},
+		{"ID": "stopped", "Status": "stopped", "StartedAt": "2021-01-01T00:00:00Z", "ExitCode": "-1", "Signal": "SIGTERM"},
+		{"ID": "timed_out", "Status": "timed_out", "StartedAt": "2021-01-01T00:00:00Z", "ExitCode": "0"},
+		{"ID": "start_failed", "Status": "errored", "ExitCode": ""}
+	]}`
+	err = ioutil.WriteFile(filepath.Join(dir, snapshotFileName), []byte(snapshot), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFileJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	expected := map[string]struct {
+		reason   ExitReason
+		exitCode int
+		signal   string
+	}{
+		"exited":       {ExitReasonExited, 3, ""},
+		"stopped":      {ExitReasonSignaled, -1, "SIGTERM"},
+		"timed_out":    {ExitReasonTimedOut, 0, ""},
+		"start_failed": {ExitReasonStartFailed, -1, ""},
+	}
+	for id, exit := range expected {
+		job, err := store.FindJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exitCode := -1
+		if job.ExitCode != nil {
+			exitCode = *job.ExitCode
+		}
+
+		if job.ExitReason != exit.reason || exitCode != exit.exitCode || job.Signal != exit.signal {
+			t.Errorf("Incorrect exit of migrated job %s: %s, %d, %q", id, job.ExitReason, exitCode, job.Signal)
+		}
+	}
+}
+
+func TestFileJobStoreNewerSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, snapshotFileName), []byte(`{"Version": 99, "Jobs": []}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenFileJobStore(dir)
+	if !errors.Is(err, ErrUnsupportedSchema) {
+		t.Errorf("Expected ErrUnsupportedSchema, but got %v", err)
+	}
+}
@@ -1,112 +1,516 @@
 package worker
 
 import (
+	"io"
 	"log"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	uuid "github.com/satori/go.uuid"
 )
 
-// The following constants are possible values for the Status of a Job
-const (
-	Completed = "completed"
-	Errored   = "errored"
-	Running   = "running"
-	Stopped   = "stopped"
-)
+// stopTimeout is how long a stopped job's processes have to exit after being sent SIGKILL
+const stopTimeout = 5 * time.Second
+
+// ErrInvalidCommand represents an error returned when the command of a Job cannot be parsed
+var ErrInvalidCommand = errors.New("worker: Invalid command")
 
-// Job represents a job created to run a Linux command
+// ErrInvalidWorkDir represents an error returned when the process of a Job cannot change to its WorkDir
+var ErrInvalidWorkDir = errors.New("worker: Unable to change to the working directory")
+
+// Job represents a job created to run a Linux command.
+// The command is either given as exact arguments in Args, or as a string in Command.
 type Job struct {
-	ID       string
-	Pid      int `json:"-"`
-	Status   string
-	Stdout   string
-	Stderr   string
-	Command  string
-	ExitCode string
-	User     string
+	ID     string
+	Pid    int `json:"-"`
+	Status JobState
+	// StatusReason explains the status of a job whose process has been Lost
+	StatusReason string
+	// Stdout and Stderr are only set by FindJobDetails, with the end of the output
+	Stdout string
+	Stderr string
+	// Command is split on whitespace, or following the POSIX shell quoting rules if ShellSplit is set
+	Command string
+	// Labels are free-form key-value pairs used to find jobs, see JobQuery
+	Labels map[string]string
+	// CreatedAt is when the job was received, StartedAt when its process started, and FinishedAt when it got
+	// its final status
+	CreatedAt  time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	// Duration is the time the job has been running, until now for a job which has not finished. It does not
+	// include PausedDuration, the time the job has spent Paused. PausedAt is when the current pause started.
+	Duration       Duration
+	PausedAt       *time.Time
+	PausedDuration Duration
+	// Usage contains the resources used by the job, sampled while it is running
+	Usage      *Usage
+	Args       []string
+	ShellSplit bool
+	// The variables in Env ("KEY=VALUE") are added to the environment of the API server, or replace it if
+	// CleanEnv is set
+	Env      []string
+	CleanEnv bool
+	WorkDir  string
+	// ExitReason explains how the job's process ended, and is empty until then. A job stopped by a user gets
+	// the reason of its process exit, whereas TimedOut is used when the job is stopped by its timeout and
+	// OOMKilled when the kernel killed it because its cgroup ran out of memory.
+	ExitReason ExitReason
+	// ExitCode is only set if the process exited on its own
+	ExitCode *int
+	// Signal is the name of the signal that terminated the process, and CoreDumped is set if it dumped a core
+	Signal     string
+	CoreDumped bool
+	User       string
+	// Credential is the Unix account the process runs as. The process runs as the server if it is not set.
+	Credential *Credential
+	Limits     ResourceLimits
+	Isolation  string
+	// SecurityProfile restricts the capabilities and system calls of the process, and defaults to
+	// SecurityProfileDefault
+	SecurityProfile string
+	// A job still running after Timeout is stopped and ends with the TimedOut status. A zero Timeout means
+	// no time limit.
+	Timeout      Duration
+	OutputLimits OutputLimits `json:"-"`
+	// OutputBytes is the number of bytes of output written by the job, and OutputTruncated is set if some
+	// of them were discarded because of the OutputLimits
+	OutputBytes     int64
+	OutputTruncated bool
+	// A job started with TTY runs under a pseudo-terminal, see Terminal: its output is all in Stdout
+	TTY bool
+	// If OpenStdin is set, the stdin is a pipe kept open until it is closed by WriteStdin, or the server
+	// stops. Otherwise, the process reads from Input, or from /dev/null if it is not set.
+	OpenStdin bool
+	// Input is closed once the process has exited if it is an io.Closer
+	Input  io.Reader `json:"-"`
+	output *JobOutput
 }
 
 // Start creates a process to run the command and save the Job to the given store.
 func (job *Job) Start(store JobStore) error {
 	job.ID = uuid.NewV4().String()
+	job.CreatedAt = time.Now().UTC()
+	job.Status = Pending
+
+	if job.Isolation == "" {
+		job.Isolation = IsolationNone
+	}
+
+	if !validIsolation(job.Isolation) {
+		job.fail()
+		store.AddJob(job)
+
+		return ErrInvalidIsolation
+	}
+
+	if job.SecurityProfile == "" {
+		job.SecurityProfile = SecurityProfileDefault
+	}
+
+	if !validSecurityProfile(job.SecurityProfile) {
+		job.fail()
+		store.AddJob(job)
+
+		return ErrInvalidSecurityProfile
+	}
+
+	args, err := job.parseCommand()
+	if err != nil {
+		job.fail()
+		store.AddJob(job)
+
+		return err
+	}
+
+	err = job.validateStdin()
+	if err != nil {
+		job.fail()
+		store.AddJob(job)
+
+		return err
+	}
+
+	env := job.environment()
+	commandPath, err := lookPath(args[0], env, job.WorkDir)
+	if err != nil {
+		job.fail()
+		store.AddJob(job)
+
+		return errors.Wrap(err, "Unable to start job")
+	}
+
+	cmd := exec.Command(commandPath, args[1:]...)
+	cmd.Args[0] = args[0]
+	cmd.Env = env
+	cmd.Dir = job.WorkDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	job.output = newJobOutput(job.ID, job.OutputLimits)
+	cmd.Stdout = job.output.Stdout
+	cmd.Stderr = job.output.Stderr
 
-	commandName, commandArguments := parseCommand(job.Command)
-	cmd := exec.Command(commandName, commandArguments...)
-	cmd.Stdout = &jobOutputWriter{outputType: "stdout", jobID: job.ID, store: store}
-	cmd.Stderr = &jobOutputWriter{outputType: "stderr", jobID: job.ID, store: store}
+	var terminal *jobTerminal
+	if job.TTY {
+		terminal, err = openTerminal()
+		if err != nil {
+			job.fail()
+			store.AddJob(job)
 
-	err := cmd.Start()
+			return errors.Wrap(err, "Unable to open job's terminal")
+		}
+
+		// The process leads a new session, and therefore a new process group, with the terminal as
+		// its controlling terminal
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = terminal.slave, terminal.slave, terminal.slave
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	}
+
+	job.setCredential(cmd)
+
+	group, err := newCgroup(job.ID, job.Limits)
+	if err != nil {
+		if terminal != nil {
+			terminal.close()
+		}
+
+		job.fail()
+		store.AddJob(job)
+
+		return errors.Wrap(err, "Unable to create job's cgroup")
+	}
+
+	stdinReader, stdinWriter, err := job.setStdin(cmd)
 	if err != nil {
-		job.Status = Errored
+		if group != nil {
+			group.remove()
+		}
+		if terminal != nil {
+			terminal.close()
+		}
+
+		job.fail()
 		store.AddJob(job)
 
+		return errors.Wrap(err, "Unable to open job's stdin")
+	}
+
+	// The persisted output can still be written while the server is not running
+	var pipes *outputPipes
+	if job.OutputLimits.Persist && terminal == nil {
+		pipes, err = openOutputPipes(job.ID)
+		if err != nil {
+			if group != nil {
+				group.remove()
+			}
+			if stdinReader != nil {
+				stdinReader.Close()
+				stdinWriter.Close()
+			}
+
+			job.fail()
+			store.AddJob(job)
+
+			return errors.Wrap(err, "Unable to open job's output")
+		}
+
+		cmd.Stdout, cmd.Stderr = pipes.writers[0], pipes.writers[1]
+	}
+
+	store.AddJob(job)
+	cmd, initStatus, err := job.startCommand(cmd, group)
+	if stdinReader != nil {
+		// Only the process reads from the pipe
+		stdinReader.Close()
+	}
+	if err != nil {
+		if group != nil {
+			group.remove()
+		}
+		if terminal != nil {
+			terminal.close()
+		}
+		if pipes != nil {
+			pipes.close()
+		}
+		if stdinWriter != nil {
+			stdinWriter.Close()
+		}
+
+		job.output.close()
+		job.fail()
+		update := JobUpdate{Status: Errored, FinishedAt: job.FinishedAt, ExitReason: ExitReasonStartFailed}
+		updateErr := store.UpdateJob(job.ID, Pending, update)
+		if updateErr != nil {
+			log.Println(updateErr)
+		}
+
 		return errors.Wrap(err, "Unable to start job")
 	}
 
+	startedAt := time.Now().UTC()
 	job.Pid = cmd.Process.Pid
 	job.Status = Running
-	store.AddJob(job)
+	job.StartedAt = &startedAt
+	process := registerProcess(job.ID)
+	if terminal != nil {
+		terminal.closeSlave()
+		process.setTerminal(terminal)
+		go terminal.copyOutput(job.output.Stdout)
+	}
+	if pipes != nil {
+		pipes.closeWriters()
+		process.setOutputPipes(pipes)
+		go pipes.copyOutput(job.output)
+	}
+	if stdinWriter != nil {
+		process.setStdin(stdinWriter)
+	}
+	if initStatus != nil {
+		process.setInitStatus(initStatus)
+	}
+
+	err = store.UpdateJob(job.ID, Pending, JobUpdate{Status: Running, Pid: job.Pid, StartedAt: job.StartedAt})
+	if err != nil {
+		log.Println(err)
+	}
+
+	if job.Timeout > 0 {
+		go process.enforceTimeout(job, store, startedAt, time.Duration(job.Timeout))
+	}
+
+	if job.OutputLimits.Policy == OutputPolicyKill {
+		go process.enforceOutputLimit(job, store)
+	}
 
 	// This goroutine will exit when the command completes or is stopped by calling Stop
-	go job.wait(cmd, store)
+	go job.wait(cmd, group, process, store)
 
 	return nil
 }
 
-// Stop attempts to stop a running command
-func (job *Job) Stop(store JobStore) error {
-	process, err := os.FindProcess(job.Pid)
-	if err != nil {
-		return errors.Wrap(err, "Error finding job's process")
+// Stop attempts to stop a running command and all the processes it has started.
+// The job is in the Stopping status until none of its processes are left, then it is Stopped.
+func (job *Job) Stop(store JobStore, options StopOptions) error {
+	process := findProcess(job.ID)
+	if process == nil {
+		return ErrJobNotRunning
 	}
 
-	err = process.Signal(syscall.SIGTERM)
+	err := process.stop(job, store, options, Stopped)
 	if err != nil {
 		return errors.Wrap(err, "Error stopping job")
 	}
 
-	values := map[string]string{"Status": Stopped, "ExitCode": "-1"}
-	store.UpdateJob(job.ID, values)
-
 	return nil
 }
 
-func (job *Job) wait(cmd *exec.Cmd, store JobStore) {
-	values := make(map[string]string)
+// wait updates the job once its process has exited. It also removes the job's cgroup,
+// including when the job has been stopped by calling Stop.
+func (job *Job) wait(cmd *exec.Cmd, group *cgroup, process *jobProcess, store JobStore) {
+	defer process.finish(job.ID)
+
+	// The process is only reaped by cmd.Wait once it is marked as exited, so that Stop
+	// never signals another process which has reused its PID
+	err := waitForExit(cmd.Process.Pid)
+	if err != nil {
+		log.Println(err)
+	}
+
+	// The job ends with its process: the processes it has started in the background are killed, and the job
+	// can still be stopped or signaled until they have exited
+	job.killRemainingProcesses()
+	stopStatus := process.markExited()
 
-	err := cmd.Wait()
+	// Wait returns once all the output of the command has been written, except for the output
+	// written to the terminal or to the output pipes, which is read until every process has closed them
+	err = cmd.Wait()
+	terminal := process.getTerminal()
+	if terminal != nil {
+		<-terminal.copied
+		terminal.master.Close()
+	}
+	process.waitForOutput()
+	job.output.close()
+	job.closeInput()
+	process.closeStdin()
 
-	if commandStoppedBySignal(cmd) {
-		return
+	status, _ := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	commandStatus, ok := process.commandStatus()
+	if ok {
+		status = commandStatus
 	}
 
+	update := exitUpdate(status)
 	if err != nil {
-		log.Println(err)
-		values["Status"] = Errored
+		if stopStatus == "" {
+			log.Println(err)
+		}
+
+		update.Status = Errored
 	} else {
-		values["Status"] = Completed
+		update.Status = Completed
+	}
+
+	rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage)
+	if ok {
+		update.Usage = rusageUsage(rusage)
 	}
 
-	values["ExitCode"] = strconv.Itoa(cmd.ProcessState.ExitCode())
-	store.UpdateJob(job.ID, values)
+	job.finish(update, stopStatus, group, process, store)
 }
 
-func parseCommand(rawCommand string) (string, []string) {
-	splitCommand := strings.Split(rawCommand, " ")
+// finish updates the job once its process has exited. A job which was being stopped gets stopStatus.
+// The usage of the job is completed with the statistics of its cgroup, before the cgroup is removed.
+// The exit reason is changed if the job has been stopped by its timeout, or killed by the kernel
+// because its cgroup ran out of memory.
+func (job *Job) finish(update JobUpdate, stopStatus JobState, group *cgroup, process *jobProcess, store JobStore) {
+	from := Running
+	if process.isPaused() {
+		from = Paused
+	}
+
+	if stopStatus != "" {
+		// Processes started by the command may still be running until they are sent SIGKILL
+		waitErr := job.waitForProcesses(process.gracePeriod + stopTimeout)
+		if waitErr != nil {
+			log.Println(waitErr)
+		}
+
+		from = Stopping
+		update.Status = stopStatus
+	}
+
+	if stopStatus == TimedOut {
+		update.ExitReason = ExitReasonTimedOut
+	}
+
+	if group != nil {
+		if stopStatus == "" && update.Signal == "SIGKILL" && group.oomKilled() {
+			update.ExitReason = ExitReasonOOMKilled
+		}
 
-	if len(splitCommand) < 2 {
-		return rawCommand, []string{}
+		if update.Usage == nil {
+			update.Usage = &Usage{}
+		}
+		update.Usage.addCgroupStats(group)
+
+		removeErr := group.remove()
+		if removeErr != nil {
+			log.Println(removeErr)
+		}
+	}
+
+	finishedAt := time.Now().UTC()
+	update.FinishedAt = &finishedAt
+	err := store.UpdateJob(job.ID, from, update)
+	if err != nil {
+		log.Println(err)
 	}
+}
 
-	return splitCommand[0], splitCommand[1:]
+// fail marks a job which could not be started as Errored
+func (job *Job) fail() {
+	finishedAt := time.Now().UTC()
+	job.Status = Errored
+	job.ExitReason = ExitReasonStartFailed
+	job.FinishedAt = &finishedAt
+	job.closeInput()
 }
 
-func commandStoppedBySignal(cmd *exec.Cmd) bool {
-	return cmd.ProcessState.ExitCode() == -1
+// runDuration returns how long the job has been running at the given time, without the time it has spent paused
+func (job *Job) runDuration(now time.Time) Duration {
+	if job.StartedAt == nil {
+		return 0
+	}
+
+	if job.FinishedAt != nil {
+		now = *job.FinishedAt
+	}
+
+	return Duration(now.Sub(*job.StartedAt)) - job.pausedDuration(now)
+}
+
+// pausedDuration returns how long the job has spent paused at the given time
+func (job *Job) pausedDuration(now time.Time) Duration {
+	if job.PausedAt == nil {
+		return job.PausedDuration
+	}
+
+	return job.PausedDuration + Duration(now.Sub(*job.PausedAt))
+}
+
+// endPause adds the current pause of the job, if any, to its PausedDuration
+func (job *Job) endPause(now time.Time) {
+	if job.PausedAt == nil {
+		return
+	}
+
+	job.PausedDuration = job.pausedDuration(now)
+	job.PausedAt = nil
+}
+
+func (job *Job) environment() []string {
+	if job.CleanEnv {
+		return append([]string{}, job.Env...)
+	}
+
+	return append(os.Environ(), job.Env...)
+}
+
+// startCommand starts the command. Jobs with a cgroup, isolation or a security profile other than unconfined
+// are started through the child process so that the command only runs once it has been moved to the cgroup,
+// its namespaces are set up and its security profile is applied. The child of an isolated job stays the init
+// of its PID namespace, and reports how the command exited through the returned pipe.
+func (job *Job) startCommand(cmd *exec.Cmd, group *cgroup) (*exec.Cmd, *os.File, error) {
+	// The child changes to the working directory as the job's account, and reports its errors
+	if group == nil && job.Isolation == IsolationNone && job.SecurityProfile == SecurityProfileUnconfined && job.WorkDir == "" {
+		return cmd, nil, cmd.Start()
+	}
+
+	child := newChildProcess(cmd)
+	child.config.SecurityProfile = job.SecurityProfile
+	if job.Isolation == IsolationNamespaces {
+		child.isolate(job.ID)
+	}
+
+	setup := func(pid int) error { return nil }
+	if group != nil {
+		setup = group.addProcess
+	}
+
+	err := child.start(setup)
+	return child.cmd, child.status, err
+}
+
+// parseCommand returns the arguments of the job's command. If the command is given in Args,
+// Command is set to the arguments joined by spaces.
+func (job *Job) parseCommand() ([]string, error) {
+	var args []string
+
+	switch {
+	case len(job.Args) > 0 && job.Command != "":
+		return nil, errors.WithMessage(ErrInvalidCommand, "Command and Args cannot both be set")
+	case len(job.Args) > 0:
+		args = job.Args
+		job.Command = strings.Join(args, " ")
+	case job.ShellSplit:
+		words, err := splitShellWords(job.Command)
+		if err != nil {
+			return nil, errors.WithMessage(ErrInvalidCommand, err.Error())
+		}
+
+		args = words
+	default:
+		args = strings.Fields(job.Command)
+	}
+
+	if len(args) == 0 || args[0] == "" {
+		return nil, errors.WithMessage(ErrInvalidCommand, "The command is empty")
+	}
+
+	return args, nil
 }
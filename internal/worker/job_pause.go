@@ -0,0 +1,259 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cgroupFreezeTimeout is how long the processes of a cgroup have to be frozen or thawed
+const cgroupFreezeTimeout = 5 * time.Second
+
+// ErrJobPaused represents an error returned when pausing a job which is already paused
+var ErrJobPaused = errors.New("worker: The job is already paused")
+
+// ErrJobNotPaused represents an error returned when resuming a job which is not paused
+var ErrJobNotPaused = errors.New("worker: The job is not paused")
+
+// Pause freezes the processes of a running job, which is Paused until Resume is called. The processes
+// of a job with a cgroup are frozen with the cgroup v2 freezer. The process group of other jobs is sent
+// SIGSTOP, which processes that have left the group do not get.
+func (job *Job) Pause(store JobStore) error {
+	process := findProcess(job.ID)
+	if process == nil {
+		return ErrJobNotRunning
+	}
+
+	err := process.pause(job, store)
+	if err != nil {
+		return errors.Wrap(err, "Error pausing job")
+	}
+
+	return nil
+}
+
+// Resume lets the processes of a Paused job run again
+func (job *Job) Resume(store JobStore) error {
+	process := findProcess(job.ID)
+	if process == nil {
+		return ErrJobNotRunning
+	}
+
+	err := process.resume(job, store)
+	if err != nil {
+		return errors.Wrap(err, "Error resuming job")
+	}
+
+	return nil
+}
+
+// pause freezes the processes of the job and marks it as Paused. A job being stopped cannot be paused.
+func (process *jobProcess) pause(job *Job, store JobStore) error {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.exited || process.stopStatus != "" {
+		return ErrJobNotRunning
+	}
+
+	if process.paused {
+		return ErrJobPaused
+	}
+
+	frozen, err := freezeProcesses(job)
+	if err != nil {
+		return err
+	}
+
+	pausedAt := time.Now().UTC()
+	err = store.UpdateJob(job.ID, Running, JobUpdate{Status: Paused, PausedAt: &pausedAt})
+	if err != nil {
+		thawErr := thawProcesses(job, frozen)
+		if thawErr != nil {
+			return thawErr
+		}
+
+		if errors.Is(err, ErrStateConflict) {
+			return ErrJobNotRunning
+		}
+
+		return err
+	}
+
+	process.setPaused(true, frozen, pausedAt)
+	return nil
+}
+
+// resume thaws the processes of the job and marks it as Running again
+func (process *jobProcess) resume(job *Job, store JobStore) error {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.exited || process.stopStatus != "" {
+		return ErrJobNotRunning
+	}
+
+	if !process.paused {
+		return ErrJobNotPaused
+	}
+
+	err := thawProcesses(job, process.frozen)
+	if err != nil {
+		return err
+	}
+
+	resumedAt := time.Now().UTC()
+	err = store.UpdateJob(job.ID, Paused, JobUpdate{Status: Running, ResumedAt: &resumedAt})
+	process.setPaused(false, false, resumedAt)
+	if errors.Is(err, ErrStateConflict) {
+		return ErrJobNotRunning
+	}
+
+	return err
+}
+
+// unpause thaws the processes of a Paused job which is being stopped, so that they get the stop signals.
+// The mutex must be held.
+func (process *jobProcess) unpause(job *Job) error {
+	if !process.paused {
+		return nil
+	}
+
+	err := thawProcesses(job, process.frozen)
+	process.setPaused(false, false, time.Now())
+	return err
+}
+
+// setPaused records a pause or resume of the job at the given time, and wakes up the goroutines
+// waiting for it. The mutex must be held.
+func (process *jobProcess) setPaused(paused, frozen bool, at time.Time) {
+	if process.paused && !paused {
+		process.pausedFor += at.Sub(process.pausedAt)
+	}
+
+	process.paused = paused
+	process.frozen = frozen
+	process.pausedAt = at
+
+	if process.pauseChanged != nil {
+		close(process.pauseChanged)
+		process.pauseChanged = nil
+	}
+}
+
+// runTime returns how long the job's process has been running since the given time, without the time
+// it has spent paused, whether it is paused, and a channel closed once it is paused or resumed
+func (process *jobProcess) runTime(since time.Time) (time.Duration, bool, <-chan struct{}) {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.pauseChanged == nil {
+		process.pauseChanged = make(chan struct{})
+	}
+
+	now := time.Now()
+	pausedFor := process.pausedFor
+	if process.paused {
+		pausedFor += now.Sub(process.pausedAt)
+	}
+
+	return now.Sub(since) - pausedFor, process.paused, process.pauseChanged
+}
+
+// isPaused returns true if the job is Paused
+func (process *jobProcess) isPaused() bool {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	return process.paused
+}
+
+// restorePause records that a re-adopted job was paused by the previous server
+func (process *jobProcess) restorePause(job *Job) {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	group := findCgroup(job.ID)
+	process.paused = true
+	process.frozen = group != nil && group.frozen()
+	process.pausedAt = time.Now()
+	if job.PausedAt != nil {
+		process.pausedAt = *job.PausedAt
+	}
+}
+
+// freezeProcesses freezes the job's cgroup, or sends SIGSTOP to its processes if the freezer is not
+// available. Returns true if the cgroup has been frozen.
+func freezeProcesses(job *Job) (bool, error) {
+	group := findCgroup(job.ID)
+	if group != nil && group.canFreeze() {
+		return true, group.freeze(true)
+	}
+
+	return false, job.signalProcesses(syscall.SIGSTOP)
+}
+
+// thawProcesses undoes freezeProcesses
+func thawProcesses(job *Job, frozen bool) error {
+	if frozen {
+		group := findCgroup(job.ID)
+		if group == nil {
+			return nil
+		}
+
+		return group.freeze(false)
+	}
+
+	return job.signalProcesses(syscall.SIGCONT)
+}
+
+// canFreeze returns true if the cgroup has the cgroup v2 freezer
+func (group *cgroup) canFreeze() bool {
+	_, err := os.Stat(filepath.Join(group.path, "cgroup.freeze"))
+	return err == nil
+}
+
+// freeze freezes or thaws the processes of the cgroup, and waits until they are
+func (group *cgroup) freeze(frozen bool) error {
+	value := "0"
+	if frozen {
+		value = "1"
+	}
+
+	err := group.write("cgroup.freeze", value)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(cgroupFreezeTimeout)
+	for group.frozen() != frozen {
+		if time.Now().After(deadline) {
+			return errors.Errorf("The processes of cgroup %s have not been frozen or thawed", group.path)
+		}
+
+		time.Sleep(cgroupRemoveInterval)
+	}
+
+	return nil
+}
+
+// frozen returns true if the processes of the cgroup are frozen, as reported by cgroup.events
+func (group *cgroup) frozen() bool {
+	content, err := ioutil.ReadFile(filepath.Join(group.path, "cgroup.events"))
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "frozen 1" {
+			return true
+		}
+	}
+
+	return false
+}
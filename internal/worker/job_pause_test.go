@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestPauseResume(t *testing.T) {
+	store := NewMemoryJobStore()
+
+	job := &Job{Args: []string{"sh", "-c", "while true; do echo tick; sleep 0.05; done"}}
+	err := job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = job.Resume(store)
+	if !errors.Is(err, ErrJobNotPaused) {
+		t.Errorf("Expected ErrJobNotPaused, but got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	err = job.Pause(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = job.Pause(store)
+	if !errors.Is(err, ErrJobPaused) {
+		t.Errorf("Expected ErrJobPaused, but got %v", err)
+	}
+
+	// Wait for the output of the last loop to be read before comparing it
+	time.Sleep(50 * time.Millisecond)
+	paused, err := FindJobDetails(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	stillPaused, err := FindJobDetails(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stillPaused.Status != Paused || stillPaused.PausedAt == nil {
+		t.Errorf("Expected the job to be paused, but got %s", stillPaused.Status)
+	}
+
+	if stillPaused.Stdout != paused.Stdout {
+		t.Errorf("Expected a paused job not to write any output, but got %q after %q", stillPaused.Stdout, paused.Stdout)
+	}
+
+	if stillPaused.Duration-paused.Duration > Duration(50*time.Millisecond) {
+		t.Errorf("Expected the duration not to grow while paused, but got %s after %s", stillPaused.Duration, paused.Duration)
+	}
+
+	err = job.Resume(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = waitForStatus(store, job.ID, Running)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	resumed, err := FindJobDetails(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed.Stdout == stillPaused.Stdout {
+		t.Errorf("Expected the job to write output again once resumed")
+	}
+
+	// A paused job can be stopped
+	err = job.Pause(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = job.Stop(store, StopOptions{GracePeriod: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != Stopped || found.PausedAt != nil {
+		t.Errorf("Expected the job to be stopped, but got %s", found.Status)
+	}
+
+	if found.PausedDuration < Duration(300*time.Millisecond) {
+		t.Errorf("Expected the job to have been paused for at least 300ms, but got %s", found.PausedDuration)
+	}
+}
+
+func TestPauseTimeout(t *testing.T) {
+	store := NewMemoryJobStore()
+
+	job := &Job{Args: []string{"sleep", "10"}, Timeout: Duration(300 * time.Millisecond)}
+	err := job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = job.Pause(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The time spent paused does not count towards the timeout
+	time.Sleep(500 * time.Millisecond)
+	found, err := store.FindJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != Paused {
+		t.Fatalf("Expected the job to still be paused, but got %s", found.Status)
+	}
+
+	err = job.Resume(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err = waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != TimedOut {
+		t.Errorf("Expected the job to time out once resumed, but got %s", found.Status)
+	}
+
+	if found.Duration >= Duration(time.Second) || found.PausedDuration < Duration(500*time.Millisecond) {
+		t.Errorf("Expected the time spent paused to be separate from the duration, but got %s and %s", found.Duration, found.PausedDuration)
+	}
+}
@@ -0,0 +1,290 @@
+package worker
+
+import (
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// pidTypePID is the idtype of waitid to wait for a single process
+const pidTypePID = 1
+
+// DefaultGracePeriod is how long a stopped job has to exit after SIGTERM before it is sent SIGKILL
+const DefaultGracePeriod = 10 * time.Second
+
+// ErrJobNotRunning represents an error returned when acting on a job whose process has already exited
+var ErrJobNotRunning = errors.New("worker: The job is not running")
+
+// StopOptions contains the options to stop a Job.
+// The job is sent SIGTERM, then SIGKILL once GracePeriod has elapsed. Force sends SIGKILL right away.
+type StopOptions struct {
+	GracePeriod time.Duration
+	Force       bool
+}
+
+// jobProcess tracks the process of a running job, from Start until wait returns
+type jobProcess struct {
+	mutex       sync.Mutex
+	exited      bool
+	stopStatus  JobState
+	gracePeriod time.Duration
+	terminal    *jobTerminal
+	outputPipes *outputPipes
+	stdin       *os.File
+	stdinBusy   bool
+	initStatus  *os.File
+	done        chan struct{}
+
+	// The pause of the job, see job_pause.go. pausedFor is the time spent in the previous pauses.
+	paused       bool
+	frozen       bool
+	pausedAt     time.Time
+	pausedFor    time.Duration
+	pauseChanged chan struct{}
+}
+
+// processes contains the jobProcess of every running job, by job ID
+var processes = struct {
+	sync.Mutex
+	jobs map[string]*jobProcess
+}{jobs: make(map[string]*jobProcess)}
+
+func registerProcess(jobID string) *jobProcess {
+	process := &jobProcess{done: make(chan struct{})}
+
+	processes.Lock()
+	processes.jobs[jobID] = process
+	processes.Unlock()
+
+	return process
+}
+
+func findProcess(jobID string) *jobProcess {
+	processes.Lock()
+	defer processes.Unlock()
+
+	return processes.jobs[jobID]
+}
+
+// setTerminal sets the terminal of a job started with TTY
+func (process *jobProcess) setTerminal(terminal *jobTerminal) {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	process.terminal = terminal
+}
+
+// getTerminal returns the terminal of the job, or nil if it was not started with TTY
+func (process *jobProcess) getTerminal() *jobTerminal {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	return process.terminal
+}
+
+// setOutputPipes sets the output pipes of a job whose output is persisted
+func (process *jobProcess) setOutputPipes(pipes *outputPipes) {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	process.outputPipes = pipes
+}
+
+// waitForOutput waits until the output written to the output pipes of the job has been read
+func (process *jobProcess) waitForOutput() {
+	process.mutex.Lock()
+	pipes := process.outputPipes
+	process.mutex.Unlock()
+
+	if pipes != nil {
+		<-pipes.copied
+	}
+}
+
+// setInitStatus sets the pipe through which the init of an isolated job reports how its command exited
+func (process *jobProcess) setInitStatus(initStatus *os.File) {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	process.initStatus = initStatus
+}
+
+// commandStatus returns how the command exited if the job's process is the init of an isolated job,
+// since the init itself cannot be killed by the signals the command gets
+func (process *jobProcess) commandStatus() (syscall.WaitStatus, bool) {
+	process.mutex.Lock()
+	initStatus := process.initStatus
+	process.initStatus = nil
+	process.mutex.Unlock()
+
+	if initStatus == nil {
+		return 0, false
+	}
+
+	return readInitStatus(initStatus)
+}
+
+// finish unregisters the process once wait has updated the job
+func (process *jobProcess) finish(jobID string) {
+	processes.Lock()
+	delete(processes.jobs, jobID)
+	processes.Unlock()
+
+	close(process.done)
+}
+
+// markExited records that the job's process has exited. If the job was being stopped,
+// returns the status the job has once stopped.
+func (process *jobProcess) markExited() JobState {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	process.exited = true
+	return process.stopStatus
+}
+
+// stop marks the job as stopping and signals its processes. It returns without waiting for the
+// processes to exit: SIGKILL is sent in the background once the grace period has elapsed.
+// The job has the given status once stopped. The job is only signaled if it can change from
+// Running, or Paused, to Stopping. The processes of a Paused job are thawed to get the signals.
+func (process *jobProcess) stop(job *Job, store JobStore, options StopOptions, status JobState) error {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.exited {
+		return ErrJobNotRunning
+	}
+
+	if process.stopStatus != "" {
+		if options.Force {
+			return job.signalProcesses(syscall.SIGKILL)
+		}
+
+		return nil
+	}
+
+	from := Running
+	update := JobUpdate{Status: Stopping}
+	if process.paused {
+		resumedAt := time.Now().UTC()
+		from = Paused
+		update.ResumedAt = &resumedAt
+	}
+
+	err := store.UpdateJob(job.ID, from, update)
+	if errors.Is(err, ErrStateConflict) {
+		return ErrJobNotRunning
+	} else if err != nil {
+		return err
+	}
+
+	err = process.unpause(job)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return process.signalStop(job, options, status)
+}
+
+// resumeStop signals the processes of a job which was already Stopping when the server restarted
+func (process *jobProcess) resumeStop(job *Job) error {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.exited {
+		return ErrJobNotRunning
+	}
+
+	return process.signalStop(job, StopOptions{GracePeriod: DefaultGracePeriod}, Stopped)
+}
+
+// signalStop sends SIGTERM, or SIGKILL if forced, to the processes of a Stopping job.
+// The mutex must be held.
+func (process *jobProcess) signalStop(job *Job, options StopOptions, status JobState) error {
+	process.stopStatus = status
+	process.gracePeriod = options.GracePeriod
+
+	signal := syscall.SIGTERM
+	if options.Force {
+		signal = syscall.SIGKILL
+	} else {
+		go process.escalate(job, options.GracePeriod)
+	}
+
+	return job.signalProcesses(signal)
+}
+
+// waitForExit blocks until the process has exited, without reaping it
+func waitForExit(pid int) error {
+	var info [128]byte
+
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pidTypePID, uintptr(pid), uintptr(unsafe.Pointer(&info)), syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		} else if errno != 0 {
+			return errors.Wrap(errno, "Unable to wait for process")
+		}
+
+		return nil
+	}
+}
+
+// escalate sends SIGKILL to the job's processes if they are still running after the grace period
+func (process *jobProcess) escalate(job *Job, gracePeriod time.Duration) {
+	timer := time.NewTimer(gracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-process.done:
+	case <-timer.C:
+		job.signalProcesses(syscall.SIGKILL)
+	}
+}
+
+// enforceTimeout stops the job once it has been running for the timeout since the given time.
+// The time spent paused does not count.
+func (process *jobProcess) enforceTimeout(job *Job, store JobStore, since time.Time, timeout time.Duration) {
+	for {
+		runTime, paused, pauseChanged := process.runTime(since)
+
+		// A paused job waits for its resume
+		timer := time.NewTimer(timeout - runTime)
+		expired := timer.C
+		if paused {
+			expired = nil
+		}
+
+		select {
+		case <-process.done:
+			timer.Stop()
+			return
+		case <-pauseChanged:
+			timer.Stop()
+		case <-expired:
+			err := process.stop(job, store, StopOptions{GracePeriod: DefaultGracePeriod}, TimedOut)
+			if err != nil && err != ErrJobNotRunning {
+				log.Println(err)
+			}
+
+			return
+		}
+	}
+}
+
+// enforceOutputLimit stops the job if it reaches the hard limit of its output
+func (process *jobProcess) enforceOutputLimit(job *Job, store JobStore) {
+	select {
+	case <-process.done:
+	case <-job.output.limitReached:
+		err := process.stop(job, store, StopOptions{Force: true}, Errored)
+		if err != nil && err != ErrJobNotRunning {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package worker
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The following constants are possible values for the Order of a JobQuery
+const (
+	OrderNewestFirst = "desc"
+	OrderOldestFirst = "asc"
+)
+
+// DefaultListLimit is the number of jobs listed per page when a JobQuery has no Limit
+const DefaultListLimit = 50
+
+// ErrInvalidCursor represents an error returned when the cursor of a JobQuery cannot be decoded
+var ErrInvalidCursor = errors.New("worker: Invalid cursor")
+
+// The following constants are possible values for the SortBy of a JobQuery
+const (
+	SortByCreatedAt  = "created_at"
+	SortByStartedAt  = "started_at"
+	SortByFinishedAt = "finished_at"
+)
+
+// JobQuery selects the jobs listed by JobStore.ListJobs. Empty fields select every job.
+// A job matches if it has one of the Statuses, if its command contains Command, if it was created,
+// started and finished in the given ranges, and if it has all the Labels. Each range includes its
+// After time but not its Before time, and a job which has not started or finished does not match
+// the corresponding range. A label with an empty value only requires the job to have the label.
+// The jobs are sorted by the time given by SortBy, by creation time by default, in the given Order,
+// newest first by default. Jobs without that time come last in the newest first order. Cursor is
+// the NextCursor of the previous page.
+type JobQuery struct {
+	User           string
+	Statuses       []JobState
+	Command        string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	StartedAfter   time.Time
+	StartedBefore  time.Time
+	FinishedAfter  time.Time
+	FinishedBefore time.Time
+	Labels         map[string]string
+	SortBy         string
+	Order          string
+	Limit          int
+	Cursor         string
+}
+
+// JobPage is a page of jobs listed by JobStore.ListJobs. The jobs do not include their output.
+// NextCursor is empty on the last page.
+type JobPage struct {
+	Jobs       []Job
+	NextCursor string
+}
+
+// jobPosition is the position of a job in the sorted jobs
+type jobPosition struct {
+	time time.Time
+	id   string
+}
+
+func (query JobQuery) matches(job Job) bool {
+	if query.User != "" && job.User != query.User {
+		return false
+	}
+
+	if len(query.Statuses) > 0 && !containsState(query.Statuses, job.Status) {
+		return false
+	}
+
+	if query.Command != "" && !strings.Contains(job.Command, query.Command) {
+		return false
+	}
+
+	if !inRange(&job.CreatedAt, query.CreatedAfter, query.CreatedBefore) ||
+		!inRange(job.StartedAt, query.StartedAfter, query.StartedBefore) ||
+		!inRange(job.FinishedAt, query.FinishedAfter, query.FinishedBefore) {
+		return false
+	}
+
+	for key, value := range query.Labels {
+		jobValue, ok := job.Labels[key]
+		if !ok || (value != "" && jobValue != value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// inRange returns true if t is in [after, before). Zero bounds are ignored.
+func inRange(t *time.Time, after, before time.Time) bool {
+	if after.IsZero() && before.IsZero() {
+		return true
+	}
+
+	if t == nil {
+		return false
+	}
+
+	return (after.IsZero() || !t.Before(after)) && (before.IsZero() || t.Before(before))
+}
+
+func (query JobQuery) position(job Job) jobPosition {
+	var t *time.Time
+	switch query.SortBy {
+	case SortByStartedAt:
+		t = job.StartedAt
+	case SortByFinishedAt:
+		t = job.FinishedAt
+	default:
+		t = &job.CreatedAt
+	}
+
+	if t == nil {
+		return jobPosition{id: job.ID}
+	}
+
+	return jobPosition{time: *t, id: job.ID}
+}
+
+// listPage sorts the jobs matching the query and returns the requested page
+func (query JobQuery) listPage(jobs []Job) (JobPage, error) {
+	newestFirst := query.Order != OrderOldestFirst
+	before := func(a, b jobPosition) bool {
+		if !a.time.Equal(b.time) {
+			return a.time.Before(b.time) != newestFirst
+		}
+
+		return a.id < b.id
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return before(query.position(jobs[i]), query.position(jobs[j])) })
+
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return JobPage{}, err
+		}
+
+		start := sort.Search(len(jobs), func(i int) bool { return before(cursor, query.position(jobs[i])) })
+		jobs = jobs[start:]
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+
+	page := JobPage{Jobs: jobs}
+	if len(jobs) > limit {
+		page.Jobs = jobs[:limit]
+		page.NextCursor = encodeCursor(query.position(page.Jobs[limit-1]))
+	}
+
+	return page, nil
+}
+
+// encodeCursor returns a cursor pointing after the position in the sorted jobs
+func encodeCursor(position jobPosition) string {
+	cursor := position.time.Format(time.RFC3339Nano) + "/" + position.id
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+// decodeCursor returns the position the cursor points after
+func decodeCursor(cursor string) (jobPosition, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return jobPosition{}, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(decoded), "/", 2)
+	if len(parts) != 2 {
+		return jobPosition{}, ErrInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return jobPosition{}, ErrInvalidCursor
+	}
+
+	return jobPosition{time: t, id: parts[1]}, nil
+}
+
+func containsState(states []JobState, state JobState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestListJobsPagination(t *testing.T) {
+	store := NewMemoryJobStore()
+	createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Jobs created at the same time are ordered by ID, so that no job is skipped between pages
+	for i := 0; i < 10; i++ {
+		store.AddJob(&Job{ID: fmt.Sprintf("job%d", i), Status: Completed, CreatedAt: createdAt.Add(time.Duration(i/3) * time.Second)})
+	}
+
+	for _, order := range []string{OrderNewestFirst, OrderOldestFirst} {
+		seen := make(map[string]bool)
+		var previous Job
+		query := JobQuery{Order: order, Limit: 4}
+
+		for pages := 0; pages < 10; pages++ {
+			page, err := store.ListJobs(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, job := range page.Jobs {
+				if seen[job.ID] {
+					t.Errorf("Job %s listed twice in %s order", job.ID, order)
+				}
+				seen[job.ID] = true
+
+				if previous.ID != "" && !previous.CreatedAt.Equal(job.CreatedAt) &&
+					previous.CreatedAt.After(job.CreatedAt) != (order == OrderNewestFirst) {
+					t.Errorf("Job %s is not sorted in %s order", job.ID, order)
+				}
+				previous = job
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+
+		if len(seen) != 10 {
+			t.Errorf("Expected to list 10 jobs in %s order, but got %d", order, len(seen))
+		}
+	}
+
+	_, err := store.ListJobs(JobQuery{Cursor: "invalid"})
+	if err != ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor, but got %v", err)
+	}
+}
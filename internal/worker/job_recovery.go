@@ -0,0 +1,220 @@
+package worker
+
+import (
+	"log"
+	"syscall"
+	"time"
+)
+
+// The following constants are possible values for the Result of a Recovery
+const (
+	RecoveryReadopted = "readopted"
+	RecoveryLost      = "lost"
+)
+
+// maxStartTimeDrift is how far the start time of a job's process can be from the job's StartedAt, which is
+// recorded once the process has started. Changes of the system clock since then also make them drift apart.
+const maxStartTimeDrift = time.Second
+
+// Recovery is the result of recovering a job that was running when the previous server stopped.
+// Reason explains why the job has been lost.
+type Recovery struct {
+	JobID  string
+	Result string
+	Reason string
+}
+
+// recover re-adopts the job's process if it is still running: the job can be stopped again, its timeout
+// is enforced from when it started, and it gets the exit status of its process. Otherwise, the job is Lost, without
+// signaling a process which has reused its PID.
+// The output of a job whose output is persisted is read again from its output pipes, kept open by its relay.
+// Otherwise, the output was written to pipes read by the previous server: a process writing more output
+// is terminated by SIGPIPE, and the job is Lost.
+// A job started with TTY is Lost, since its terminal was closed with the previous server.
+func (job *Job) recover(store JobStore) Recovery {
+	if job.Status == Pending {
+		return job.markLost(store, Pending, "The server stopped while the job was starting")
+	}
+
+	if job.TTY {
+		return job.markLost(store, job.Status, "The terminal of the job was closed when the server stopped")
+	}
+
+	if !job.processRunning() {
+		return job.markLost(store, job.Status, "The process exited while the server was not running")
+	}
+
+	if !job.isJobProcess() {
+		// The process which has reused the PID, and its process group, must not be signaled
+		job.Pid = 0
+		return job.markLost(store, job.Status, "The process exited while the server was not running, and its PID has been reused")
+	}
+
+	exited, err := traceExit(job.Pid)
+	if err != nil {
+		log.Println(err)
+		return job.markLost(store, job.Status, "The process could not be re-adopted, so it has been killed")
+	}
+
+	process := registerProcess(job.ID)
+	if job.OutputLimits.Persist {
+		job.reattachOutput(store, process)
+	}
+
+	if job.Status == Paused {
+		process.restorePause(job)
+	}
+
+	// Only the rest of the timeout is enforced, and the job is stopped at once if it has already expired
+	if job.Timeout > 0 {
+		since := job.StartedAt.Add(time.Duration(job.PausedDuration))
+		go process.enforceTimeout(job, store, since, time.Duration(job.Timeout))
+	}
+
+	if job.Status == Stopping {
+		err = process.resumeStop(job)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	go job.waitReadopted(exited, findCgroup(job.ID), process, store)
+
+	return Recovery{JobID: job.ID, Result: RecoveryReadopted}
+}
+
+// waitReadopted updates a re-adopted job once its process has exited
+func (job *Job) waitReadopted(exited <-chan syscall.WaitStatus, group *cgroup, process *jobProcess, store JobStore) {
+	defer process.finish(job.ID)
+
+	status, ok := <-exited
+	job.killRemainingProcesses()
+	stopStatus := process.markExited()
+	process.waitForOutput()
+	if job.output != nil {
+		job.output.close()
+	}
+
+	from := Running
+	if process.isPaused() {
+		from = Paused
+	}
+	if stopStatus != "" {
+		from = Stopping
+	}
+
+	if !ok {
+		job.markLost(store, from, "The exit status of the re-adopted process could not be collected")
+		return
+	}
+
+	// The process and the ones it started have been reaped, so their process group must not be signaled
+	if job.output == nil && status.Signaled() && status.Signal() == syscall.SIGPIPE {
+		job.Pid = 0
+		job.markLost(store, from, "The process was killed by SIGPIPE when writing output, since its output was read by the previous server")
+		return
+	}
+
+	update := exitUpdate(status)
+	update.Status = Completed
+	if !status.Exited() || status.ExitStatus() != 0 {
+		update.Status = Errored
+	}
+
+	job.finish(update, stopStatus, group, process, store)
+}
+
+// reattachOutput reads the output pipes of a re-adopted job again, and appends their output to the output
+// written by the previous server
+func (job *Job) reattachOutput(store JobStore, process *jobProcess) {
+	output, err := store.FindOutput(job.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	pipes := reattachOutputPipes(job.ID)
+	if pipes == nil {
+		return
+	}
+
+	job.output = output
+	output.reopen()
+	process.setOutputPipes(pipes)
+	go pipes.copyOutput(output)
+}
+
+// processRunning returns true if the job's process is still running. A process with the same PID
+// which is not the leader of its process group is another process which has reused the PID.
+func (job *Job) processRunning() bool {
+	if job.Pid <= 0 {
+		return false
+	}
+
+	state, processGroup, err := processStat(job.Pid)
+	if err != nil {
+		return false
+	}
+
+	return processGroup == job.Pid && state != "Z" && state != "X"
+}
+
+// isJobProcess returns true if the process running with the job's PID is the job's process: it started
+// when the job did, and it is in the job's cgroup if the job has one. Otherwise, another process has reused the PID.
+func (job *Job) isJobProcess() bool {
+	if job.StartedAt == nil {
+		return false
+	}
+
+	startTime, err := processStartTime(job.Pid)
+	if err != nil {
+		return false
+	}
+
+	drift := startTime.Sub(*job.StartedAt)
+	if drift < -maxStartTimeDrift || drift > maxStartTimeDrift {
+		return false
+	}
+
+	group := findCgroup(job.ID)
+	if group == nil {
+		return true
+	}
+
+	pids, err := group.processes()
+	if err != nil {
+		return false
+	}
+
+	for _, pid := range pids {
+		if pid == job.Pid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markLost changes the job from the given state to Lost, and kills the processes it may have left
+func (job *Job) markLost(store JobStore, from JobState, reason string) Recovery {
+	if job.Pid > 0 {
+		job.signalProcesses(syscall.SIGKILL)
+	}
+
+	group := findCgroup(job.ID)
+	if group != nil {
+		err := group.remove()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	finishedAt := time.Now().UTC()
+	update := JobUpdate{Status: Lost, StatusReason: reason, FinishedAt: &finishedAt}
+	err := store.UpdateJob(job.ID, from, update)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return Recovery{JobID: job.ID, Result: RecoveryLost, Reason: reason}
+}
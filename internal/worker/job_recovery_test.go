@@ -0,0 +1,311 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRecoverJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Processes left running by a previous server, which nothing waits for
+	exiting := startOrphan(t, "sh", "-c", "sleep 1; exit 3")
+	sleeping := startOrphan(t, "sleep", "30")
+	terminal := startOrphan(t, "sleep", "30")
+	paused := startOrphan(t, "sleep", "30")
+	err = syscall.Kill(paused, syscall.SIGSTOP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A process which has reused the PID of a job that started earlier
+	reused := startOrphan(t, "sleep", "30")
+	defer syscall.Kill(reused, syscall.SIGKILL)
+	startedAt := time.Now().UTC()
+	reusedAt := startedAt.Add(-time.Hour)
+
+	exited := exec.Command("true")
+	err = exited.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFileJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.AddJob(&Job{ID: "exiting", Pid: exiting, Status: Running, StartedAt: &startedAt})
+	store.AddJob(&Job{ID: "sleeping", Pid: sleeping, Status: Running, StartedAt: &startedAt})
+	store.AddJob(&Job{ID: "exited", Pid: exited.Process.Pid, Status: Running, StartedAt: &startedAt})
+	store.AddJob(&Job{ID: "pending", Status: Pending})
+	store.AddJob(&Job{ID: "terminal", Pid: terminal, Status: Running, StartedAt: &startedAt, TTY: true})
+	pausedAt := time.Now().UTC()
+	store.AddJob(&Job{ID: "paused", Pid: paused, Status: Paused, StartedAt: &startedAt, PausedAt: &pausedAt})
+	store.AddJob(&Job{ID: "reused", Pid: reused, Status: Running, StartedAt: &reusedAt})
+	store.AddJob(&Job{ID: "completed", Status: Completed})
+	store.Close()
+
+	store, err = OpenFileJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	results := make(map[string]string)
+	for _, recovery := range store.RecoverJobs() {
+		results[recovery.JobID] = recovery.Result
+	}
+
+	expected := map[string]string{"exiting": RecoveryReadopted, "sleeping": RecoveryReadopted, "exited": RecoveryLost, "pending": RecoveryLost, "terminal": RecoveryLost, "paused": RecoveryReadopted, "reused": RecoveryLost}
+	for id, result := range expected {
+		if results[id] != result {
+			t.Errorf("Expected job %s to be %s, but got %q", id, result, results[id])
+		}
+	}
+
+	if len(results) != len(expected) {
+		t.Errorf("Expected %d recovered jobs, but got %v", len(expected), results)
+	}
+
+	job, err := store.FindJob("exited")
+	if err != nil || job.Status != Lost || job.StatusReason == "" {
+		t.Errorf("Expected the exited job to be lost with a reason, but got %+v, %v", job, err)
+	}
+
+	alive, err := processGroupAlive(reused)
+	if err != nil || !alive {
+		t.Errorf("Expected the process which has reused the PID of a job to be left running, but got %t, %v", alive, err)
+	}
+
+	job, err = waitForStatus(store, "exiting", Errored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.ExitCode == nil || *job.ExitCode != 3 {
+		t.Errorf("Expected the exit code of the re-adopted process, but got %v", job.ExitCode)
+	}
+
+	job, err = store.FindJob("sleeping")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = job.Stop(store, StopOptions{GracePeriod: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err = waitForStatus(store, "sleeping", Stopped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Signal != "SIGTERM" {
+		t.Errorf("Expected the re-adopted process to be terminated by SIGTERM, but got %q", job.Signal)
+	}
+
+	// A re-adopted job which was paused can be resumed, then stopped
+	job, err = store.FindJob("paused")
+	if err != nil || job.Status != Paused {
+		t.Fatalf("Expected the re-adopted job to still be paused, but got %+v, %v", job, err)
+	}
+
+	err = job.Resume(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = job.Stop(store, StopOptions{GracePeriod: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err = waitForStatus(store, "paused", Stopped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Signal != "SIGTERM" || job.PausedDuration <= 0 {
+		t.Errorf("Expected the resumed process to be terminated by SIGTERM after a pause, but got %q after %s", job.Signal, job.PausedDuration)
+	}
+}
+
+func TestRecoverTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expired := startOrphan(t, "sleep", "30")
+	expiredAt := time.Now().UTC()
+	remaining := startOrphan(t, "sleep", "30")
+	remainingAt := time.Now().UTC()
+
+	store, err := OpenFileJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	store.AddJob(&Job{ID: "expired", Pid: expired, Status: Running, StartedAt: &expiredAt, Timeout: Duration(300 * time.Millisecond)})
+	store.AddJob(&Job{ID: "remaining", Pid: remaining, Status: Running, StartedAt: &remainingAt, Timeout: Duration(time.Second)})
+
+	// The server was not running for longer than the timeout of the first job
+	time.Sleep(500 * time.Millisecond)
+	recoveredAt := time.Now()
+	store.RecoverJobs()
+
+	job, err := waitForStatus(store, "expired", TimedOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.FinishedAt.Sub(recoveredAt) > 200*time.Millisecond {
+		t.Errorf("Expected the job to be stopped once re-adopted, but it finished after %s", job.FinishedAt.Sub(recoveredAt))
+	}
+
+	job, err = waitForStatus(store, "remaining", TimedOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if finishedAfter := job.FinishedAt.Sub(recoveredAt); finishedAfter > 800*time.Millisecond {
+		t.Errorf("Expected the job to be stopped after the rest of its timeout, but it finished after %s", finishedAfter)
+	}
+}
+
+func TestRecoverOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The processes of the previous server write output once it has stopped reading their pipes
+	limits := OutputLimits{Dir: filepath.Join(dir, "output"), Persist: true}
+	pipes, err := openOutputPipes("relayed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relayed := exec.Command("sh", "-c", "sleep 0.5; echo after; echo error >&2")
+	relayed.Stdout, relayed.Stderr = pipes.writers[0], pipes.writers[1]
+	relayed.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = relayed.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayedAt := time.Now().UTC()
+	pipes.close()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unrelayed := exec.Command("sh", "-c", "sleep 0.5; echo after")
+	unrelayed.Stdout = writer
+	unrelayed.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = unrelayed.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unrelayedAt := time.Now().UTC()
+	reader.Close()
+	writer.Close()
+
+	err = os.MkdirAll(limits.Dir, 0700)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(limits.Dir, "relayed.stdout"), []byte("before\n"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFileJobStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.AddJob(&Job{ID: "relayed", Pid: relayed.Process.Pid, Status: Running, StartedAt: &relayedAt, OutputLimits: limits})
+	store.AddJob(&Job{ID: "unrelayed", Pid: unrelayed.Process.Pid, Status: Running, StartedAt: &unrelayedAt, OutputLimits: limits})
+	store.Close()
+
+	store, err = OpenFileJobStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, recovery := range store.RecoverJobs() {
+		if recovery.Result != RecoveryReadopted {
+			t.Errorf("Expected job %s to be re-adopted, but got %+v", recovery.JobID, recovery)
+		}
+	}
+
+	_, err = waitForStatus(store, "relayed", Completed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := FindJobDetails(store, "relayed")
+	if err != nil || job.Stdout != "before\nafter\n" || job.Stderr != "error\n" {
+		t.Errorf("Expected the output to be read again from the relay, but got %q, %q, %v", job.Stdout, job.Stderr, err)
+	}
+
+	job, err = waitForStatus(store, "unrelayed", Lost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(job.StatusReason, "SIGPIPE") {
+		t.Errorf("Expected the job to be lost because of SIGPIPE, but got %q", job.StatusReason)
+	}
+}
+
+// startOrphan starts a process in its own process group, like the process of a job
+func startOrphan(t *testing.T, name string, args ...string) int {
+	cmd := exec.Command(name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd.Process.Pid
+}
+
+func waitForStatus(store JobStore, jobID string, status JobState) (Job, error) {
+	var job Job
+	var err error
+
+	for i := 0; i < 100; i++ {
+		job, err = store.FindJob(jobID)
+		if err == nil && job.Status == status {
+			return job, nil
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err != nil {
+		return job, err
+	}
+
+	return job, errors.Errorf("Expected job %s to be %s, but got %s", jobID, status, job.Status)
+}
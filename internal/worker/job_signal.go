@@ -0,0 +1,101 @@
+package worker
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidSignal represents an error returned when sending a signal which is not in AllowedSignals
+var ErrInvalidSignal = errors.New("worker: Invalid signal")
+
+// AllowedSignals are the signals that can be sent to a job with SendSignal. The signals that stop,
+// continue or kill a job are left to Stop, Pause and Resume, which keep its status up to date.
+var AllowedSignals = []syscall.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGALRM,
+	syscall.SIGWINCH,
+}
+
+// AllowedSignalNames returns the names of AllowedSignals, e.g. "SIGHUP"
+func AllowedSignalNames() []string {
+	names := make([]string, len(AllowedSignals))
+	for i, signal := range AllowedSignals {
+		names[i] = signalName(signal)
+	}
+
+	return names
+}
+
+// ParseSignal returns the allowed signal with the given name, such as "SIGHUP" or "HUP", in any case
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	for _, signal := range AllowedSignals {
+		if signalName(signal) == name {
+			return signal, nil
+		}
+	}
+
+	return 0, ErrInvalidSignal
+}
+
+// SendSignal sends the signal with the given name, which must be in AllowedSignals, to the process group
+// of a running job, and to the processes of its cgroup. The status of the job does not change, unless its
+// process exits because of the signal. The processes of a Paused job get the signal once resumed.
+// A job_signaled Event is published.
+func (job *Job) SendSignal(store JobStore, name string) error {
+	signal, err := ParseSignal(name)
+	if err != nil {
+		return err
+	}
+
+	process := findProcess(job.ID)
+	if process == nil {
+		return ErrJobNotRunning
+	}
+
+	err = process.signal(job, store, signal)
+	if err != nil {
+		return errors.Wrap(err, "Error signaling job")
+	}
+
+	return nil
+}
+
+// signal sends the signal to the processes of the job, unless its process has exited
+func (process *jobProcess) signal(job *Job, store JobStore, signal syscall.Signal) error {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.exited {
+		return ErrJobNotRunning
+	}
+
+	err := job.signalProcesses(signal)
+	if err == syscall.ESRCH {
+		return ErrJobNotRunning
+	} else if err != nil {
+		return err
+	}
+
+	// The status changes of the job are made with the mutex held, so the event has the current status
+	status := Running
+	if process.stopStatus != "" {
+		status = Stopping
+	} else if process.paused {
+		status = Paused
+	}
+
+	store.Events().publish(Event{Type: EventJobSignaled, JobID: job.ID, User: job.User, Status: status, Signal: signalName(signal)})
+	return nil
+}
@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSendSignal(t *testing.T) {
+	store := NewMemoryJobStore()
+
+	job := &Job{Args: []string{"sh", "-c", "trap 'echo reloaded' HUP; echo ready; while true; do sleep 0.05; done"}, User: "user1"}
+	err := job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := store.FindOutput(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The signal would terminate the shell before its trap is set
+	err = waitForOutput(output.Stdout, "ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscription, _, _ := store.Events().Subscribe("", 0)
+	defer subscription.Close()
+
+	err = job.SendSignal(store, "hup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-subscription.Events:
+		if event.Type != EventJobSignaled || event.Signal != "SIGHUP" || event.Status != Running || event.User != "user1" {
+			t.Errorf("Expected a job_signaled event for SIGHUP, but got %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a job_signaled event")
+	}
+
+	err = waitForOutput(output.Stdout, "reloaded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := store.FindJob(job.ID)
+	if err != nil || found.Status != Running {
+		t.Errorf("Expected the job to keep running after SIGHUP, but got %+v, %v", found, err)
+	}
+
+	for _, name := range []string{"KILL", "SIGSTOP", "CONT", "SIGNOPE", ""} {
+		err = job.SendSignal(store, name)
+		if !errors.Is(err, ErrInvalidSignal) {
+			t.Errorf("Expected ErrInvalidSignal when sending %q, but got %v", name, err)
+		}
+	}
+
+	err = job.SendSignal(store, "SIGTERM")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err = waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != Errored || found.Signal != "SIGTERM" {
+		t.Errorf("Expected the job to be terminated by SIGTERM, but got %s with signal %q", found.Status, found.Signal)
+	}
+
+	err = job.SendSignal(store, "SIGHUP")
+	if !errors.Is(err, ErrJobNotRunning) {
+		t.Errorf("Expected ErrJobNotRunning, but got %v", err)
+	}
+}
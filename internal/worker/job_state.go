@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// JobState is the status of a Job
+type JobState string
+
+// The following constants are possible values for the Status of a Job
+const (
+	Pending   JobState = "pending"
+	Running   JobState = "running"
+	Paused    JobState = "paused"
+	Stopping  JobState = "stopping"
+	Completed JobState = "completed"
+	Errored   JobState = "errored"
+	Stopped   JobState = "stopped"
+	TimedOut  JobState = "timed_out"
+	Lost      JobState = "lost"
+)
+
+// transitions contains the states each state can change to. A job is Pending while its process is
+// being started. A running job is Paused while its processes are frozen, and Stopping once it has
+// been sent SIGTERM, until none of its processes are left. A job is Lost if the server could not
+// follow its process across a restart. The states which cannot change are final.
+var transitions = map[JobState][]JobState{
+	Pending:  {Running, Errored, Lost},
+	Running:  {Paused, Stopping, Completed, Errored, Lost},
+	Paused:   {Running, Stopping, Completed, Errored, Lost},
+	Stopping: {Stopped, TimedOut, Errored, Lost},
+}
+
+// JobStates contains every JobState
+var JobStates = []JobState{Pending, Running, Paused, Stopping, Completed, Errored, Stopped, TimedOut, Lost}
+
+// ErrIllegalTransition represents an error returned when a job cannot change from its state to the requested one
+var ErrIllegalTransition = errors.New("worker: Illegal job state transition")
+
+// ErrStateConflict represents an error returned when a job is updated from a state it is no longer in
+var ErrStateConflict = errors.New("worker: The job's state has changed")
+
+// TransitionError is returned when a job cannot change from state From to state To.
+// It matches ErrIllegalTransition with errors.Is.
+type TransitionError struct {
+	JobID string
+	From  JobState
+	To    JobState
+}
+
+func (err *TransitionError) Error() string {
+	return fmt.Sprintf("worker: Job %s cannot change from %s to %s", err.JobID, err.From, err.To)
+}
+
+// Is makes errors.Is match the error with ErrIllegalTransition
+func (err *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// StateConflictError is returned when a job is updated from state Expected while it is in state Actual.
+// It matches ErrStateConflict with errors.Is.
+type StateConflictError struct {
+	JobID    string
+	Expected JobState
+	Actual   JobState
+}
+
+func (err *StateConflictError) Error() string {
+	return fmt.Sprintf("worker: Job %s is %s, not %s", err.JobID, err.Actual, err.Expected)
+}
+
+// Is makes errors.Is match the error with ErrStateConflict
+func (err *StateConflictError) Is(target error) bool {
+	return target == ErrStateConflict
+}
+
+// Valid returns true if the state is one of the JobStates
+func (state JobState) Valid() bool {
+	for _, s := range JobStates {
+		if s == state {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Final returns true if the job cannot change state anymore
+func (state JobState) Final() bool {
+	return len(transitions[state]) == 0
+}
+
+// CanTransitionTo returns true if a job can change from the state to the next one
+func (state JobState) CanTransitionTo(next JobState) bool {
+	for _, s := range transitions[state] {
+		if s == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// JobUpdate contains the changes applied to a Job by JobStore.UpdateJob. Zero fields are left unchanged,
+// except for the fields describing the exit of the job's process, which are all set with ExitReason.
+// PausedAt starts a pause of the job, and ResumedAt or FinishedAt ends it.
+type JobUpdate struct {
+	Status       JobState
+	StatusReason string
+	Pid          int
+	StartedAt    *time.Time
+	PausedAt     *time.Time
+	ResumedAt    *time.Time
+	FinishedAt   *time.Time
+	ExitReason   ExitReason
+	ExitCode     *int
+	Signal       string
+	CoreDumped   bool
+	Usage        *Usage
+}
+
+// apply changes the job from the state from, after checking that the job is in this state
+// and that it can change to the new state
+func (update JobUpdate) apply(job *Job, from JobState) error {
+	if job.Status != from {
+		return &StateConflictError{JobID: job.ID, Expected: from, Actual: job.Status}
+	}
+
+	if update.Status != "" {
+		if !from.CanTransitionTo(update.Status) {
+			return &TransitionError{JobID: job.ID, From: from, To: update.Status}
+		}
+
+		job.Status = update.Status
+	}
+
+	if update.StatusReason != "" {
+		job.StatusReason = update.StatusReason
+	}
+	if update.Pid != 0 {
+		job.Pid = update.Pid
+	}
+	if update.StartedAt != nil {
+		job.StartedAt = update.StartedAt
+	}
+	if update.PausedAt != nil {
+		job.PausedAt = update.PausedAt
+	}
+	if update.ResumedAt != nil {
+		job.endPause(*update.ResumedAt)
+	}
+	if update.FinishedAt != nil {
+		job.endPause(*update.FinishedAt)
+		job.FinishedAt = update.FinishedAt
+	}
+	if update.ExitReason != "" {
+		job.ExitReason = update.ExitReason
+		job.ExitCode = update.ExitCode
+		job.Signal = update.Signal
+		job.CoreDumped = update.CoreDumped
+	}
+	if update.Usage != nil {
+		job.Usage = update.Usage
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestJobStateTransitions(t *testing.T) {
+	tests := []struct {
+		from  JobState
+		to    JobState
+		legal bool
+	}{
+		{Pending, Running, true},
+		{Pending, Errored, true},
+		{Pending, Completed, false},
+		{Running, Stopping, true},
+		{Running, Completed, true},
+		{Running, Stopped, false},
+		{Running, Pending, false},
+		{Stopping, Stopped, true},
+		{Stopping, TimedOut, true},
+		{Stopping, Running, false},
+		{Completed, Errored, false},
+		{Stopped, Running, false},
+		{Lost, Running, false},
+	}
+
+	for _, test := range tests {
+		store := NewMemoryJobStore()
+		store.AddJob(&Job{ID: "job", Status: test.from})
+
+		err := store.UpdateJob("job", test.from, JobUpdate{Status: test.to})
+		if test.legal && err != nil {
+			t.Errorf("Expected %s -> %s to be legal, but got %v", test.from, test.to, err)
+		}
+
+		if !test.legal {
+			var transitionErr *TransitionError
+			if !errors.Is(err, ErrIllegalTransition) || !errors.As(err, &transitionErr) ||
+				transitionErr.From != test.from || transitionErr.To != test.to {
+				t.Errorf("Expected a TransitionError for %s -> %s, but got %v", test.from, test.to, err)
+			}
+
+			job, _ := store.FindJob("job")
+			if job.Status != test.from {
+				t.Errorf("Expected an illegal transition to leave the job %s, but it is %s", test.from, job.Status)
+			}
+		}
+	}
+
+	for _, state := range JobStates {
+		final := state == Completed || state == Errored || state == Stopped || state == TimedOut || state == Lost
+		if state.Final() != final {
+			t.Errorf("Expected %s.Final() to be %v", state, final)
+		}
+	}
+}
+
+func TestUpdateJobCompareAndSet(t *testing.T) {
+	store := NewMemoryJobStore()
+	finalStates := []JobState{Completed, Errored, Lost}
+
+	for i := 0; i < 100; i++ {
+		jobID := fmt.Sprintf("job%d", i)
+		store.AddJob(&Job{ID: jobID, Status: Running})
+
+		var wg sync.WaitGroup
+		results := make(chan error, 2*len(finalStates))
+		for _, state := range finalStates {
+			for _, update := range []JobUpdate{{Status: state}, {Status: Stopping}} {
+				wg.Add(1)
+				go func(update JobUpdate) {
+					defer wg.Done()
+					results <- store.UpdateJob(jobID, Running, update)
+				}(update)
+			}
+		}
+		wg.Wait()
+		close(results)
+
+		succeeded := 0
+		for err := range results {
+			if err == nil {
+				succeeded++
+				continue
+			}
+
+			var conflictErr *StateConflictError
+			if !errors.Is(err, ErrStateConflict) || !errors.As(err, &conflictErr) || conflictErr.Expected != Running {
+				t.Errorf("Expected a StateConflictError, but got %v", err)
+			}
+		}
+
+		if succeeded != 1 {
+			t.Errorf("Expected exactly one update of %s to succeed, but got %d", jobID, succeeded)
+		}
+	}
+}
+
+func TestStopRacingExit(t *testing.T) {
+	store := NewMemoryJobStore()
+
+	for i := 0; i < 20; i++ {
+		job := &Job{Args: []string{"true"}}
+		err := job.Start(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = job.Stop(store, StopOptions{})
+		if err != nil && !errors.Is(err, ErrJobNotRunning) {
+			t.Errorf("Expected Stop to succeed or the job not to be running, but got %v", err)
+		}
+
+		found, err := waitForFinalStatus(store, job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.Status != Completed && found.Status != Stopped {
+			t.Errorf("Expected the job to be completed or stopped, but got %s", found.Status)
+		}
+	}
+}
+
+// waitForFinalStatus waits until the job has a final status, and returns it with its output
+func waitForFinalStatus(store JobStore, jobID string) (Job, error) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := FindJobDetails(store, jobID)
+		if err != nil {
+			return Job{}, err
+		}
+
+		if job.Status.Final() {
+			return job, nil
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return Job{}, errors.Errorf("Job %s has not finished", jobID)
+}
@@ -3,22 +3,59 @@ package worker
 import (
 	"errors"
 	"sync"
+	"time"
 )
 
 // ErrJobNotFound represents an error returned when a job cannot be found in the store
 var ErrJobNotFound = errors.New("worker: Unable to find job in store")
 
-// JobStore defines an interface for saving, updating and finding a Job.
+// JobStore defines an interface for saving, updating, finding and listing Jobs, and for finding their output.
+// The changes of the jobs are published on the EventBus returned by Events.
 type JobStore interface {
 	AddJob(*Job)
-	UpdateJob(string, map[string]string) error
+	UpdateJob(string, JobState, JobUpdate) error
 	FindJob(string) (Job, error)
+	FindOutput(string) (*JobOutput, error)
+	ListJobs(JobQuery) (JobPage, error)
+	Events() *EventBus
 }
 
-// MemoryJobStore implements the JobStore interface and stores Jobs in memory
+// MemoryJobStore implements the JobStore interface and stores Jobs in memory.
+// The output of the jobs is kept in their JobOutput, which has its own lock: writing
+// or reading the output does not lock the store. The events are published while the store is locked,
+// so that they are in the order of the changes.
 type MemoryJobStore struct {
-	Jobs  map[string]Job
-	mutex sync.RWMutex
+	Jobs    map[string]Job
+	outputs map[string]*JobOutput
+	events  *EventBus
+	mutex   sync.RWMutex
+}
+
+// NewMemoryJobStore returns an empty MemoryJobStore
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{
+		Jobs:    make(map[string]Job),
+		outputs: make(map[string]*JobOutput),
+		events:  NewEventBus(DefaultEventHistorySize),
+	}
+}
+
+// Close removes the files to which the output of the jobs has spilled, since nothing refers to them once
+// the store is dropped. The output of the jobs cannot be read afterwards.
+func (store *MemoryJobStore) Close() error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, output := range store.outputs {
+		output.removeFiles()
+	}
+
+	return nil
+}
+
+// Events returns the EventBus on which the changes of the jobs are published
+func (store *MemoryJobStore) Events() *EventBus {
+	return store.events
 }
 
 // AddJob adds a Job to the memory store
@@ -26,79 +63,215 @@ func (store *MemoryJobStore) AddJob(job *Job) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
+	jobCopy := store.insertJob(job)
+	store.events.publish(jobEvent(EventJobCreated, jobCopy, ""))
+}
+
+// insertJob adds a copy of the Job and its output to the store, and returns the copy.
+// The mutex must be held.
+func (store *MemoryJobStore) insertJob(job *Job) Job {
+	output := job.output
+	if output == nil {
+		output = newJobOutput(job.ID, OutputLimits{})
+		output.close()
+	}
+	store.outputs[job.ID] = output
+
 	jobCopy := Job{
-		ID:       job.ID,
-		Pid:      job.Pid,
-		Status:   job.Status,
-		Stdout:   job.Stdout,
-		Stderr:   job.Stderr,
-		Command:  job.Command,
-		ExitCode: job.ExitCode,
-		User:     job.User,
+		ID:              job.ID,
+		Pid:             job.Pid,
+		Status:          job.Status,
+		StatusReason:    job.StatusReason,
+		Command:         job.Command,
+		Labels:          job.Labels,
+		CreatedAt:       job.CreatedAt,
+		StartedAt:       job.StartedAt,
+		FinishedAt:      job.FinishedAt,
+		PausedAt:        job.PausedAt,
+		PausedDuration:  job.PausedDuration,
+		Usage:           job.Usage,
+		Args:            job.Args,
+		ShellSplit:      job.ShellSplit,
+		Env:             job.Env,
+		CleanEnv:        job.CleanEnv,
+		WorkDir:         job.WorkDir,
+		ExitReason:      job.ExitReason,
+		ExitCode:        job.ExitCode,
+		Signal:          job.Signal,
+		CoreDumped:      job.CoreDumped,
+		User:            job.User,
+		Credential:      job.Credential,
+		Limits:          job.Limits,
+		Isolation:       job.Isolation,
+		SecurityProfile: job.SecurityProfile,
+		Timeout:         job.Timeout,
+		OutputLimits:    job.OutputLimits,
+		TTY:             job.TTY,
+		OpenStdin:       job.OpenStdin,
 	}
 	store.Jobs[job.ID] = jobCopy
+
+	return jobCopy
 }
 
-// UpdateJob updates the values of a Job in the store
-func (store *MemoryJobStore) UpdateJob(jobID string, values map[string]string) error {
+// UpdateJob atomically applies the update to a Job in the store, if the job is still in the state from.
+// Returns a *StateConflictError if the job is in another state, or a *TransitionError if it cannot change
+// to the new state.
+func (store *MemoryJobStore) UpdateJob(jobID string, from JobState, update JobUpdate) error {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
+	job, err := store.updatedJob(jobID, from, update)
+	if err != nil {
+		return err
+	}
+
+	store.replaceJob(job, from)
+	return nil
+}
+
+// updatedJob returns a copy of the Job with the update applied, without changing the store.
+// The mutex must be held.
+func (store *MemoryJobStore) updatedJob(jobID string, from JobState, update JobUpdate) (Job, error) {
 	job, ok := store.Jobs[jobID]
 	if !ok {
-		return errors.New("worker: Unable to find job in store")
+		return Job{}, ErrJobNotFound
 	}
 
-	newStatus, ok := values["Status"]
-	if ok {
-		job.Status = newStatus
+	err := update.apply(&job, from)
+	if err != nil {
+		return Job{}, err
 	}
 
-	newStdout, ok := values["Stdout"]
-	if ok {
-		job.Stdout = newStdout
+	return job, nil
+}
+
+// replaceJob stores the Job updated from the state from, and publishes its change of status.
+// The mutex must be held.
+func (store *MemoryJobStore) replaceJob(job Job, from JobState) {
+	store.Jobs[job.ID] = job
+	if job.Status != from {
+		store.events.publish(jobEvent(EventJobStatusChanged, job, from))
 	}
+}
+
+// outputTailSize is the maximum number of bytes of each output stream returned by FindJobDetails
+const outputTailSize = 64 * 1024
 
-	newStderr, ok := values["Stderr"]
-	if ok {
-		job.Stderr = newStderr
+// FindJob returns a copy of the Job, without its output, if it is found. Otherwise, returns an error.
+func (store *MemoryJobStore) FindJob(id string) (Job, error) {
+	store.mutex.RLock()
+	job, ok := store.Jobs[id]
+	output := store.outputs[id]
+	store.mutex.RUnlock()
+
+	if !ok {
+		return Job{}, ErrJobNotFound
 	}
 
-	newCommand, ok := values["Command"]
-	if ok {
-		job.Command = newCommand
+	now := time.Now()
+	jobCopy := Job{
+		ID:              job.ID,
+		Pid:             job.Pid,
+		Status:          job.Status,
+		StatusReason:    job.StatusReason,
+		Command:         job.Command,
+		Labels:          job.Labels,
+		CreatedAt:       job.CreatedAt,
+		StartedAt:       job.StartedAt,
+		FinishedAt:      job.FinishedAt,
+		Duration:        job.runDuration(now),
+		PausedAt:        job.PausedAt,
+		PausedDuration:  job.pausedDuration(now),
+		Usage:           job.Usage,
+		Args:            job.Args,
+		ShellSplit:      job.ShellSplit,
+		Env:             job.Env,
+		CleanEnv:        job.CleanEnv,
+		WorkDir:         job.WorkDir,
+		ExitReason:      job.ExitReason,
+		ExitCode:        job.ExitCode,
+		Signal:          job.Signal,
+		CoreDumped:      job.CoreDumped,
+		User:            job.User,
+		Credential:      job.Credential,
+		Limits:          job.Limits,
+		Isolation:       job.Isolation,
+		SecurityProfile: job.SecurityProfile,
+		Timeout:         job.Timeout,
+		OutputLimits:    job.OutputLimits,
+		OutputBytes:     output.TotalBytes(),
+		OutputTruncated: output.Truncated(),
+		TTY:             job.TTY,
+		OpenStdin:       job.OpenStdin,
 	}
 
-	newExitCode, ok := values["ExitCode"]
-	if ok {
-		job.ExitCode = newExitCode
+	return jobCopy, nil
+}
+
+// FindJobDetails returns a copy of the Job like FindJob, with the last bytes of its output in Stdout and Stderr.
+// The whole output can be read from the JobOutput returned by FindOutput. The Usage of a job which has not
+// finished is sampled from its processes, which reads /proc.
+func FindJobDetails(store JobStore, id string) (Job, error) {
+	job, err := store.FindJob(id)
+	if err != nil {
+		return job, err
 	}
 
-	store.Jobs[job.ID] = job
+	if job.Usage == nil && (job.Status == Running || job.Status == Paused || job.Status == Stopping) {
+		job.Usage = job.sampleUsage()
+	}
 
-	return nil
+	output, err := store.FindOutput(id)
+	if err != nil {
+		return job, err
+	}
+
+	job.Stdout = output.Stdout.Tail(outputTailSize)
+	job.Stderr = output.Stderr.Tail(outputTailSize)
+
+	return job, nil
 }
 
-// FindJob returns a copy of the Job if it is found. Otherwise, returns an error.
-func (store *MemoryJobStore) FindJob(id string) (Job, error) {
+// FindOutput returns the output of a Job if it is found. Otherwise, returns an error.
+func (store *MemoryJobStore) FindOutput(id string) (*JobOutput, error) {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
-	job, ok := store.Jobs[id]
+	output, ok := store.outputs[id]
 	if !ok {
-		return Job{}, ErrJobNotFound
+		return nil, ErrJobNotFound
 	}
 
-	jobCopy := Job{
-		ID:       job.ID,
-		Pid:      job.Pid,
-		Status:   job.Status,
-		Stdout:   job.Stdout,
-		Stderr:   job.Stderr,
-		Command:  job.Command,
-		ExitCode: job.ExitCode,
-		User:     job.User,
+	return output, nil
+}
+
+// ListJobs returns a page of the Jobs matching the query, without their output
+func (store *MemoryJobStore) ListJobs(query JobQuery) (JobPage, error) {
+	store.mutex.RLock()
+	var jobs []Job
+	outputs := make(map[string]*JobOutput)
+	for id, job := range store.Jobs {
+		if query.matches(job) {
+			jobs = append(jobs, job)
+			outputs[id] = store.outputs[id]
+		}
 	}
+	store.mutex.RUnlock()
 
-	return jobCopy, nil
+	page, err := query.listPage(jobs)
+	if err != nil {
+		return JobPage{}, err
+	}
+
+	now := time.Now()
+	for i := range page.Jobs {
+		page.Jobs[i].Duration = page.Jobs[i].runDuration(now)
+		page.Jobs[i].PausedDuration = page.Jobs[i].pausedDuration(now)
+		output := outputs[page.Jobs[i].ID]
+		page.Jobs[i].OutputBytes = output.TotalBytes()
+		page.Jobs[i].OutputTruncated = output.Truncated()
+	}
+
+	return page, nil
 }
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// lookPath finds the executable of a command the way exec.LookPath does, but using the PATH of
+// the job's environment and resolving relative paths from the job's working directory.
+func lookPath(file string, env []string, dir string) (string, error) {
+	if strings.Contains(file, "/") {
+		path := file
+		if !filepath.IsAbs(path) && dir != "" {
+			path = filepath.Join(dir, path)
+		}
+
+		err := findExecutable(path)
+		if err != nil {
+			return "", errors.Wrapf(err, "exec: %q", file)
+		}
+
+		return path, nil
+	}
+
+	for _, pathDir := range filepath.SplitList(envValue(env, "PATH")) {
+		if pathDir == "" {
+			pathDir = "."
+		}
+
+		path := filepath.Join(pathDir, file)
+		if !filepath.IsAbs(path) && dir != "" {
+			path = filepath.Join(dir, path)
+		}
+
+		if findExecutable(path) == nil {
+			return path, nil
+		}
+	}
+
+	return "", errors.Errorf("exec: %q: executable file not found in $PATH", file)
+}
+
+func findExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return os.ErrPermission
+	}
+
+	return nil
+}
+
+// envValue returns the value of the last variable with the given name in env
+func envValue(env []string, name string) string {
+	prefix := name + "="
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], prefix) {
+			return env[i][len(prefix):]
+		}
+	}
+
+	return ""
+}
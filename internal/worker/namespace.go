@@ -0,0 +1,192 @@
+package worker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// The following constants are possible values for the Isolation of a Job
+const (
+	IsolationNone       = "none"
+	IsolationNamespaces = "namespaces"
+)
+
+// namespaceCloneFlags are the namespaces created for a job running with IsolationNamespaces
+const namespaceCloneFlags = syscall.CLONE_NEWPID |
+	syscall.CLONE_NEWNS |
+	syscall.CLONE_NEWUTS |
+	syscall.CLONE_NEWIPC |
+	syscall.CLONE_NEWNET
+
+// ErrInvalidIsolation represents an error returned when a Job has an unknown Isolation
+var ErrInvalidIsolation = errors.New("worker: Invalid isolation mode")
+
+func validIsolation(isolation string) bool {
+	return isolation == IsolationNone || isolation == IsolationNamespaces
+}
+
+// setupNamespaces runs in the child, inside the new namespaces. It keeps mount events from
+// propagating to the host, mounts a /proc for the new PID namespace, sets the hostname
+// and brings up the loopback interface of the new network namespace.
+func setupNamespaces(hostname string) error {
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return errors.Wrap(err, "Unable to make mounts private")
+	}
+
+	err = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NOEXEC|syscall.MS_NODEV, "")
+	if err != nil {
+		return errors.Wrap(err, "Unable to mount /proc")
+	}
+
+	err = syscall.Sethostname([]byte(hostname))
+	if err != nil {
+		return errors.Wrap(err, "Unable to set hostname")
+	}
+
+	err = setLoopbackUp()
+	if err != nil {
+		return errors.Wrap(err, "Unable to bring up loopback interface")
+	}
+
+	return nil
+}
+
+// runInit runs in the child of an isolated job, once the namespaces are set up. The child stays the init
+// of the job's PID namespace, since the kernel drops the signals that the init does not handle, which most
+// commands do not expect. The command is run by a new child, which sets up the rest of the job's process.
+// The init forwards the allowed signals to the command, reaps the orphaned processes of the namespace,
+// and exits once the command has exited. It reports how the command exited to the parent through the
+// status pipe, and exits with the same code, or 128 plus the signal that killed the command.
+func runInit(config childConfig, errorPipe *os.File) {
+	statusPipe := os.NewFile(childStatusFd, "status")
+	syscall.CloseOnExec(childStatusFd)
+
+	signals := make(chan os.Signal, len(AllowedSignals))
+	for _, allowedSignal := range AllowedSignals {
+		signal.Notify(signals, allowedSignal)
+	}
+
+	configReader, configWriter, err := os.Pipe()
+	if err != nil {
+		exitChild(errorPipe, errors.Wrap(err, "Unable to start command"))
+	}
+
+	// The new child inherits the process group of the job, and reports its errors to the parent
+	config.Isolated = false
+	cmd := &exec.Cmd{
+		Path:       "/proc/self/exe",
+		Args:       []string{childProcessName},
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		ExtraFiles: []*os.File{configReader, errorPipe},
+	}
+	err = cmd.Start()
+	configReader.Close()
+	if err != nil {
+		exitChild(errorPipe, errors.Wrap(err, "Unable to start command"))
+	}
+	errorPipe.Close()
+
+	// The new child exits if it cannot read the config
+	json.NewEncoder(configWriter).Encode(config)
+	configWriter.Close()
+
+	go forwardSignals(signals, cmd.Process.Pid)
+	status := reapProcesses(cmd.Process.Pid)
+
+	binary.Write(statusPipe, binary.LittleEndian, uint32(status))
+	statusPipe.Close()
+
+	if status.Signaled() {
+		os.Exit(128 + int(status.Signal()))
+	}
+	os.Exit(status.ExitStatus())
+}
+
+// forwardSignals sends the signals received by the init to the command. The signals sent to the job's
+// process group already reach the command, unless it has left the group.
+func forwardSignals(signals <-chan os.Signal, pid int) {
+	for received := range signals {
+		processGroup, err := syscall.Getpgid(pid)
+		if err != nil || processGroup == syscall.Getpgrp() {
+			continue
+		}
+
+		syscall.Kill(pid, received.(syscall.Signal))
+	}
+}
+
+// reapProcesses reaps the processes of the namespace until the command has exited, and returns its status
+func reapProcesses(pid int) syscall.WaitStatus {
+	for {
+		var status syscall.WaitStatus
+		reaped, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			// The command has already been reaped, which cannot happen since the init is its parent
+			return syscall.WaitStatus(1 << 8)
+		}
+
+		if reaped == pid {
+			return status
+		}
+	}
+}
+
+// readInitStatus returns how the command of an isolated job exited, as reported by its init.
+// Returns false if the init exited without reporting it.
+func readInitStatus(status *os.File) (syscall.WaitStatus, bool) {
+	var value uint32
+	err := binary.Read(status, binary.LittleEndian, &value)
+	status.Close()
+	if err != nil {
+		return 0, false
+	}
+
+	return syscall.WaitStatus(value), true
+}
+
+// ifreqFlags mirrors the layout of struct ifreq used by the SIOCGIFFLAGS and SIOCSIFFLAGS ioctls
+type ifreqFlags struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+func setLoopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	var request ifreqFlags
+	copy(request.name[:], "lo")
+
+	err = ioctl(fd, syscall.SIOCGIFFLAGS, unsafe.Pointer(&request))
+	if err != nil {
+		return err
+	}
+
+	request.flags |= syscall.IFF_UP
+	return ioctl(fd, syscall.SIOCSIFFLAGS, unsafe.Pointer(&request))
+}
+
+func ioctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
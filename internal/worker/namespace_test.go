@@ -0,0 +1,58 @@
+package worker
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIsolatedJobInit(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating namespaces requires root")
+	}
+
+	store := NewMemoryJobStore()
+
+	// sleep does not handle SIGTERM, which the kernel would drop if it were the init of the namespace
+	job := &Job{Args: []string{"sh", "-c", "echo started; exec sleep 30"}, Isolation: IsolationNamespaces}
+	err := job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = waitForOutput(job.output.Stdout, "started")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = job.Stop(store, StopOptions{GracePeriod: DefaultGracePeriod})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != Stopped || found.Signal != "SIGTERM" || time.Since(start) > DefaultGracePeriod/2 {
+		t.Errorf("Expected the isolated job to be stopped by SIGTERM, but got %s, %q after %s", found.Status, found.Signal, time.Since(start))
+	}
+
+	// The orphaned processes are reaped by the init, and the exit code is the command's
+	job = &Job{Args: []string{"sh", "-c", "(true &); sleep 0.3; grep -c zombie /proc/[0-9]*/status | grep -vc ':0$'; exit 3"}, Isolation: IsolationNamespaces}
+	err = job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err = waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Stdout != "0\n" || found.ExitReason != ExitReasonExited || found.ExitCode == nil || *found.ExitCode != 3 {
+		t.Errorf("Expected no zombie and the exit code 3, but got %q, %q, %s %v", found.Stdout, found.Stderr, found.ExitReason, found.ExitCode)
+	}
+}
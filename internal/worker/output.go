@@ -0,0 +1,398 @@
+package worker
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// outputChunkSize is the size of the chunks in which an OutputBuffer stores the output
+const outputChunkSize = 64 * 1024
+
+// The following constants are possible values for the Policy of OutputLimits
+const (
+	OutputPolicyTruncate = "truncate"
+	OutputPolicyKill     = "kill"
+)
+
+// OutputLimits limits the output of a Job. The first MemoryLimit bytes of output are kept in memory,
+// the rest is written to files in Dir. Once the job has written HardLimit bytes, the rest of its output
+// is discarded, and the job is stopped if Policy is OutputPolicyKill. Zero limits mean no limit.
+// If Persist is set, the whole output is written to the files so that it outlives the server, and
+// the memory only keeps a copy of its beginning.
+type OutputLimits struct {
+	MemoryLimit int64
+	HardLimit   int64
+	Policy      string
+	Dir         string
+	Persist     bool
+}
+
+// JobOutput contains the output of a Job. It is written by the job's process
+// and read through the JobStore, independently of the job's record.
+// changed is closed, and replaced by the next call to changes, when output is written or the streams are closed.
+type JobOutput struct {
+	Stdout *OutputBuffer
+	Stderr *OutputBuffer
+
+	limits       OutputLimits
+	mutex        sync.Mutex
+	memoryUsed   int64
+	stored       int64
+	total        int64
+	truncated    bool
+	limitReached chan struct{}
+	changed      chan struct{}
+}
+
+func newJobOutput(jobID string, limits OutputLimits) *JobOutput {
+	output := &JobOutput{limits: limits, limitReached: make(chan struct{})}
+	output.Stdout = &OutputBuffer{output: output, filePath: filepath.Join(limits.Dir, jobID+".stdout")}
+	output.Stderr = &OutputBuffer{output: output, filePath: filepath.Join(limits.Dir, jobID+".stderr")}
+
+	return output
+}
+
+// openJobOutput returns the output of a job that has been written to files by a previous server
+func openJobOutput(jobID string, limits OutputLimits, total int64, truncated bool) *JobOutput {
+	output := newJobOutput(jobID, limits)
+	output.total = total
+	output.truncated = truncated
+
+	for _, buffer := range []*OutputBuffer{output.Stdout, output.Stderr} {
+		info, err := os.Stat(buffer.filePath)
+		if err == nil {
+			buffer.size = info.Size()
+		}
+
+		buffer.closed = true
+		output.stored += buffer.size
+	}
+
+	return output
+}
+
+// reopen lets a job re-adopted by a new server append its output to the files written by the previous server
+func (output *JobOutput) reopen() {
+	for _, buffer := range []*OutputBuffer{output.Stdout, output.Stderr} {
+		buffer.mutex.Lock()
+		buffer.closed = false
+		buffer.memoryFull = true
+		buffer.appending = true
+		buffer.mutex.Unlock()
+	}
+}
+
+// TotalBytes returns the number of bytes written by the job, including the discarded ones
+func (output *JobOutput) TotalBytes() int64 {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	return output.total
+}
+
+// Truncated returns true if part of the output has been discarded because of the hard limit
+func (output *JobOutput) Truncated() bool {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	return output.truncated
+}
+
+// admit counts n bytes of output and returns how many of them can be stored
+func (output *JobOutput) admit(n int) int {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	output.total += int64(n)
+	if output.limits.HardLimit == 0 || output.stored+int64(n) <= output.limits.HardLimit {
+		output.stored += int64(n)
+		return n
+	}
+
+	admitted := int(output.limits.HardLimit - output.stored)
+	output.stored = output.limits.HardLimit
+	if !output.truncated {
+		output.truncated = true
+		close(output.limitReached)
+	}
+
+	return admitted
+}
+
+// reserveMemory returns how many of n bytes can be kept in memory
+func (output *JobOutput) reserveMemory(n int) int {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	if output.limits.MemoryLimit == 0 {
+		return n
+	}
+
+	reserved := n
+	if available := output.limits.MemoryLimit - output.memoryUsed; int64(n) > available {
+		reserved = int(available)
+	}
+
+	output.memoryUsed += int64(reserved)
+	return reserved
+}
+
+// changes returns a channel which is closed once more output is written or the streams are closed
+func (output *JobOutput) changes() <-chan struct{} {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	if output.changed == nil {
+		output.changed = make(chan struct{})
+	}
+
+	return output.changed
+}
+
+// notify wakes up the readers waiting for changes
+func (output *JobOutput) notify() {
+	output.mutex.Lock()
+	defer output.mutex.Unlock()
+
+	if output.changed != nil {
+		close(output.changed)
+		output.changed = nil
+	}
+}
+
+func (output *JobOutput) close() {
+	output.Stdout.close()
+	output.Stderr.close()
+}
+
+// removeFiles removes the files to which the output has spilled, unless they are persisted.
+// The output cannot be read once they are removed.
+func (output *JobOutput) removeFiles() {
+	if output.limits.Persist {
+		return
+	}
+
+	output.Stdout.removeFile()
+	output.Stderr.removeFile()
+}
+
+// OutputBuffer is an append-only buffer of an output stream. The output is stored in fixed-size chunks:
+// appending never copies the existing output, and any range can be read by its byte offset.
+// Once the job's memory limit is reached, the rest of the stream is appended to a file.
+// fileOffset is the offset in the stream of the first byte of the file. The file is truncated when it is
+// opened, unless appending is set because the stream was started by a previous server.
+type OutputBuffer struct {
+	mutex      sync.RWMutex
+	chunks     [][]byte
+	memorySize int64
+	memoryFull bool
+	size       int64
+	closed     bool
+	output     *JobOutput
+	filePath   string
+	fileOffset int64
+	file       *os.File
+	appending  bool
+}
+
+// Write appends p to the buffer. It never fails, so that the job can keep writing output
+// that is discarded because of the hard limit.
+func (buffer *OutputBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	n := len(p)
+	if buffer.output != nil {
+		p = p[:buffer.output.admit(n)]
+	}
+
+	inMemory := 0
+	if !buffer.memoryFull {
+		inMemory = len(p)
+		if buffer.output != nil {
+			inMemory = buffer.output.reserveMemory(len(p))
+		}
+
+		buffer.appendToChunks(p[:inMemory])
+		buffer.memoryFull = inMemory < len(p)
+	}
+
+	toFile := p[inMemory:]
+	if buffer.output != nil && buffer.output.limits.Persist {
+		toFile = p
+	}
+
+	if len(toFile) > 0 {
+		err := buffer.appendToFile(toFile)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	buffer.size += int64(len(p))
+	if buffer.output != nil && len(p) > 0 {
+		buffer.output.notify()
+	}
+
+	return n, nil
+}
+
+func (buffer *OutputBuffer) appendToChunks(p []byte) {
+	buffer.memorySize += int64(len(p))
+
+	for len(p) > 0 {
+		last := len(buffer.chunks) - 1
+		if last == -1 || len(buffer.chunks[last]) == outputChunkSize {
+			buffer.chunks = append(buffer.chunks, make([]byte, 0, outputChunkSize))
+			last++
+		}
+
+		chunk := buffer.chunks[last]
+		written := copy(chunk[len(chunk):outputChunkSize], p)
+		buffer.chunks[last] = chunk[:len(chunk)+written]
+		p = p[written:]
+	}
+}
+
+func (buffer *OutputBuffer) appendToFile(p []byte) error {
+	if buffer.file == nil {
+		err := os.MkdirAll(filepath.Dir(buffer.filePath), 0700)
+		if err != nil {
+			return err
+		}
+
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if buffer.appending {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+
+		buffer.file, err = os.OpenFile(buffer.filePath, flags, 0600)
+		if err != nil {
+			return err
+		}
+
+		if !buffer.output.limits.Persist {
+			buffer.fileOffset = buffer.memorySize
+		}
+	}
+
+	_, err := buffer.file.Write(p)
+	return err
+}
+
+// ReadAt reads len(p) bytes of output starting at offset.
+// Like io.ReaderAt, it returns io.EOF if fewer bytes are available.
+func (buffer *OutputBuffer) ReadAt(p []byte, offset int64) (int, error) {
+	buffer.mutex.RLock()
+	defer buffer.mutex.RUnlock()
+
+	if offset < 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n := 0
+	for n < len(p) && offset < buffer.memorySize {
+		chunk := buffer.chunks[offset/outputChunkSize]
+		read := copy(p[n:], chunk[offset%outputChunkSize:])
+		n += read
+		offset += int64(read)
+	}
+
+	if n < len(p) && offset < buffer.size {
+		read, err := buffer.readFile(p[n:], offset-buffer.fileOffset)
+		n += read
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (buffer *OutputBuffer) readFile(p []byte, offset int64) (int, error) {
+	file, err := os.Open(buffer.filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return file.ReadAt(p, offset)
+}
+
+// Size returns the number of bytes stored in the buffer
+func (buffer *OutputBuffer) Size() int64 {
+	buffer.mutex.RLock()
+	defer buffer.mutex.RUnlock()
+
+	return buffer.size
+}
+
+// Closed returns true once the job's process has exited and no more output will be written
+func (buffer *OutputBuffer) Closed() bool {
+	buffer.mutex.RLock()
+	defer buffer.mutex.RUnlock()
+
+	return buffer.closed
+}
+
+// String returns a copy of the whole output
+func (buffer *OutputBuffer) String() string {
+	return buffer.Tail(buffer.Size())
+}
+
+// Tail returns a copy of the last n bytes of output, or of the whole output if it is shorter
+func (buffer *OutputBuffer) Tail(n int64) string {
+	size := buffer.Size()
+	if n > size {
+		n = size
+	}
+
+	content := make([]byte, n)
+	read, _ := buffer.ReadAt(content, size-n)
+	return string(content[:read])
+}
+
+func (buffer *OutputBuffer) removeFile() {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	if buffer.size == buffer.memorySize {
+		return
+	}
+
+	if buffer.file != nil {
+		buffer.file.Close()
+		buffer.file = nil
+	}
+
+	err := os.Remove(buffer.filePath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+}
+
+func (buffer *OutputBuffer) close() {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	buffer.closed = true
+	if buffer.file != nil {
+		err := buffer.file.Close()
+		if err != nil {
+			log.Println(err)
+		}
+
+		buffer.file = nil
+	}
+
+	if buffer.output != nil {
+		buffer.output.notify()
+	}
+}
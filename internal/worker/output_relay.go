@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// relayProcessName is the argv[0] used when the worker re-executes its own binary to hold the output pipes
+// of a job. Its second argument is the ID of the job.
+const relayProcessName = "worker-relay"
+
+// relayPipeFd is the descriptor of the first pipe held by the relay, followed by the other
+const relayPipeFd = 3
+
+// pollHangup is the POLLHUP event of poll
+const pollHangup = 0x10
+
+// outputPipes are the pipes of the stdout and stderr of a job whose output is persisted. A relay process
+// holds their read ends too, without reading them, so that the job's processes do not get SIGPIPE when the
+// server stops: they block once the pipes are full instead, until a new server re-adopts the job and reads
+// them again. The relay exits once every process has closed the pipes.
+// copied is closed once all the output has been read.
+type outputPipes struct {
+	readers []*os.File
+	writers []*os.File
+	copied  chan struct{}
+}
+
+func init() {
+	if len(os.Args) > 1 && os.Args[0] == relayProcessName {
+		runRelay()
+	}
+}
+
+// runRelay waits until every process has closed the write ends of the pipes, then exits
+func runRelay() {
+	fds := []pollFd{{fd: relayPipeFd}, {fd: relayPipeFd + 1}}
+	remaining := len(fds)
+	for remaining > 0 {
+		// POLLHUP is always reported, without asking for the events which would be reported while output is pending
+		_, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])), uintptr(len(fds)), 0, 0, 0, 0)
+		if errno != 0 && errno != syscall.EINTR {
+			os.Exit(1)
+		}
+
+		// A pipe which has been hung up is no longer polled
+		for i := range fds {
+			if fds[i].fd >= 0 && fds[i].revents&pollHangup != 0 {
+				fds[i].fd = -1
+				remaining--
+			}
+		}
+	}
+
+	os.Exit(0)
+}
+
+// pollFd mirrors struct pollfd
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+// openOutputPipes opens the output pipes of the job, and starts the relay holding them
+func openOutputPipes(jobID string) (*outputPipes, error) {
+	pipes := &outputPipes{copied: make(chan struct{})}
+	for i := 0; i < 2; i++ {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			pipes.close()
+			return nil, err
+		}
+
+		pipes.readers = append(pipes.readers, reader)
+		pipes.writers = append(pipes.writers, writer)
+	}
+
+	// The relay leads its own session, so that it gets neither the signals of the job nor of the server's terminal
+	relay := &exec.Cmd{
+		Path:        "/proc/self/exe",
+		Args:        []string{relayProcessName, jobID},
+		ExtraFiles:  pipes.readers,
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	err := relay.Start()
+	if err != nil {
+		pipes.close()
+		return nil, errors.Wrap(err, "Unable to start the output relay")
+	}
+	go relay.Wait()
+
+	return pipes, nil
+}
+
+// reattachOutputPipes opens the output pipes of a job started by a previous server, through its relay.
+// Returns nil if the job has no relay.
+func reattachOutputPipes(jobID string) *outputPipes {
+	relay, ok := findRelay(jobID)
+	if !ok {
+		return nil
+	}
+
+	pipes := &outputPipes{copied: make(chan struct{})}
+	for i := 0; i < 2; i++ {
+		// The pipe is not opened in blocking mode, since the open would block if its writers have just exited
+		path := filepath.Join("/proc", strconv.Itoa(relay), "fd", strconv.Itoa(relayPipeFd+i))
+		reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			log.Println(errors.Wrap(err, "Unable to reattach the output of the job"))
+			pipes.close()
+			return nil
+		}
+
+		pipes.readers = append(pipes.readers, reader)
+	}
+
+	return pipes
+}
+
+// findRelay returns the PID of the relay of the job
+func findRelay(jobID string) (int, bool) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, false
+	}
+
+	expected := []byte(relayProcessName + "\x00" + jobID + "\x00")
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		cmdline, err := ioutil.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err == nil && bytes.Equal(cmdline, expected) {
+			return pid, true
+		}
+	}
+
+	return 0, false
+}
+
+// closeWriters closes the write ends of the pipes once the job's process has inherited them
+func (pipes *outputPipes) closeWriters() {
+	for _, writer := range pipes.writers {
+		writer.Close()
+	}
+	pipes.writers = nil
+}
+
+func (pipes *outputPipes) close() {
+	pipes.closeWriters()
+	for _, reader := range pipes.readers {
+		reader.Close()
+	}
+}
+
+// copyOutput copies the output of the job to its buffers until every process has closed the pipes
+func (pipes *outputPipes) copyOutput(output *JobOutput) {
+	defer close(pipes.copied)
+
+	done := make(chan struct{})
+	for i, buffer := range []*OutputBuffer{output.Stdout, output.Stderr} {
+		go func(reader *os.File, buffer *OutputBuffer) {
+			_, err := io.Copy(buffer, reader)
+			if err != nil {
+				log.Println(err)
+			}
+
+			reader.Close()
+			done <- struct{}{}
+		}(pipes.readers[i], buffer)
+	}
+
+	<-done
+	<-done
+}
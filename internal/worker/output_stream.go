@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// The following constants are possible values for the stream of JobOutput.Stream
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	StreamBoth   = "both"
+)
+
+// ErrInvalidStream represents an error returned when streaming an output stream which does not exist
+var ErrInvalidStream = errors.New("worker: Invalid output stream")
+
+// Stream writes the output of the given stream to w from its beginning. With StreamBoth, stdout
+// and stderr are interleaved as they are read. If follow is set, Stream keeps writing the new output
+// as it is written, until the job's process has exited and all its output has been written, or ctx is done.
+func (output *JobOutput) Stream(ctx context.Context, w io.Writer, stream string, follow bool) error {
+	return output.StreamFrom(ctx, w, stream, 0, follow)
+}
+
+// StreamFrom is like Stream, but starts writing each stream at offset. A negative offset is counted
+// back from the end of the output written so far.
+func (output *JobOutput) StreamFrom(ctx context.Context, w io.Writer, stream string, offset int64, follow bool) error {
+	var buffers []*OutputBuffer
+	switch stream {
+	case StreamStdout:
+		buffers = []*OutputBuffer{output.Stdout}
+	case StreamStderr:
+		buffers = []*OutputBuffer{output.Stderr}
+	case StreamBoth:
+		buffers = []*OutputBuffer{output.Stdout, output.Stderr}
+	default:
+		return ErrInvalidStream
+	}
+
+	offsets := make([]int64, len(buffers))
+	for i, buffer := range buffers {
+		offsets[i] = offset
+		if offset < 0 {
+			offsets[i] = buffer.Size() + offset
+		}
+
+		if offsets[i] < 0 {
+			offsets[i] = 0
+		}
+	}
+
+	p := make([]byte, outputChunkSize)
+	for {
+		// Taken before reading, so that no output written after the reads is missed
+		changed := output.changes()
+		closed := true
+		for _, buffer := range buffers {
+			closed = closed && buffer.Closed()
+		}
+
+		for i, buffer := range buffers {
+			for {
+				n, err := buffer.ReadAt(p, offsets[i])
+				if n > 0 {
+					_, writeErr := w.Write(p[:n])
+					if writeErr != nil {
+						return writeErr
+					}
+
+					offsets[i] += int64(n)
+				}
+
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return errors.Wrap(err, "Unable to read job output")
+				}
+			}
+		}
+
+		if !follow || closed {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOutputBuffer(t *testing.T) {
+	var buffer OutputBuffer
+	var expected bytes.Buffer
+
+	// Writes of various sizes, so that they start and end inside and across chunks
+	for i := 0; i < 200; i++ {
+		p := bytes.Repeat([]byte{byte('a' + i%26)}, i*97)
+		buffer.Write(p)
+		expected.Write(p)
+	}
+
+	if buffer.Size() != int64(expected.Len()) {
+		t.Fatalf("Expected size %d, but got %d", expected.Len(), buffer.Size())
+	}
+
+	if buffer.String() != expected.String() {
+		t.Fatal("The content of the buffer is not correct")
+	}
+
+	ranges := [][2]int{{0, 10}, {outputChunkSize - 5, 10}, {outputChunkSize, outputChunkSize * 2}, {expected.Len() - 3, 3}}
+	for _, r := range ranges {
+		p := make([]byte, r[1])
+		n, err := buffer.ReadAt(p, int64(r[0]))
+		if err != nil {
+			t.Errorf("Unexpected error reading %d bytes at %d: %v", r[1], r[0], err)
+		}
+
+		if !bytes.Equal(p[:n], expected.Bytes()[r[0]:r[0]+r[1]]) {
+			t.Errorf("Incorrect content reading %d bytes at %d", r[1], r[0])
+		}
+	}
+
+	p := make([]byte, 10)
+	n, err := buffer.ReadAt(p, int64(expected.Len()-4))
+	if n != 4 || err != io.EOF {
+		t.Errorf("Expected to read 4 bytes with io.EOF at the end of the buffer, but got %d bytes and %v", n, err)
+	}
+}
+
+func TestOutputBufferSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := newJobOutput("job", OutputLimits{MemoryLimit: 100, HardLimit: 250, Dir: dir})
+	output.Stdout.Write(bytes.Repeat([]byte("a"), 60))
+	output.Stderr.Write(bytes.Repeat([]byte("b"), 60))
+	output.Stdout.Write(bytes.Repeat([]byte("c"), 100))
+	output.Stdout.Write(bytes.Repeat([]byte("d"), 100))
+	output.close()
+
+	expected := strings.Repeat("a", 60) + strings.Repeat("c", 100) + strings.Repeat("d", 30)
+	if output.Stdout.String() != expected {
+		t.Errorf("Incorrect stdout.\nExpected: %s\nGot: %s", expected, output.Stdout.String())
+	}
+
+	// The stderr exceeds the memory limit shared with stdout
+	if output.Stderr.String() != strings.Repeat("b", 60) {
+		t.Errorf("Incorrect stderr: %s", output.Stderr.String())
+	}
+
+	p := make([]byte, 20)
+	n, err := output.Stdout.ReadAt(p, 50)
+	if err != nil || string(p[:n]) != strings.Repeat("a", 10)+strings.Repeat("c", 10) {
+		t.Errorf("Incorrect read across memory and file: %s, %v", p[:n], err)
+	}
+
+	tail := output.Stdout.Tail(40)
+	if tail != strings.Repeat("c", 10)+strings.Repeat("d", 30) {
+		t.Errorf("Incorrect end of stdout across memory and file: %s", tail)
+	}
+
+	if output.Stderr.Tail(1000) != output.Stderr.String() {
+		t.Errorf("Expected the end of a short stream to be the whole stream, but got %s", output.Stderr.Tail(1000))
+	}
+
+	if output.TotalBytes() != 320 || !output.Truncated() {
+		t.Errorf("Expected 320 bytes of truncated output, but got %d bytes, truncated: %t", output.TotalBytes(), output.Truncated())
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "job.stdout"))
+	if err != nil || len(content) != 130 {
+		t.Errorf("Expected 130 bytes of stdout on disk, but got %d bytes, %v", len(content), err)
+	}
+
+	store := NewMemoryJobStore()
+	store.AddJob(&Job{ID: "job", Status: Completed, output: output})
+	store.Close()
+
+	_, err = os.Stat(filepath.Join(dir, "job.stdout"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected the stdout on disk to be removed with the store, but got %v", err)
+	}
+}
+
+func TestFindJobDetails(t *testing.T) {
+	store := NewMemoryJobStore()
+	output := newJobOutput("job", OutputLimits{})
+	output.Stdout.Write([]byte("start\n"))
+	output.Stdout.Write(bytes.Repeat([]byte("a"), outputTailSize))
+	output.Stderr.Write([]byte("error\n"))
+	output.close()
+	store.AddJob(&Job{ID: "job", Status: Completed, output: output})
+
+	found, err := store.FindJob("job")
+	if err != nil || found.Stdout != "" || found.Stderr != "" || found.OutputBytes != outputTailSize+12 {
+		t.Errorf("Expected the job without its output, but got %q, %q, %d bytes, %v", found.Stdout, found.Stderr, found.OutputBytes, err)
+	}
+
+	found, err = FindJobDetails(store, "job")
+	if err != nil || found.Stdout != strings.Repeat("a", outputTailSize) || found.Stderr != "error\n" {
+		t.Errorf("Expected the job with the end of its output, but got %d bytes of stdout, %q, %v", len(found.Stdout), found.Stderr, err)
+	}
+
+	// Only the details of a running job contain its sampled usage
+	store.AddJob(&Job{ID: "running", Status: Running})
+	found, err = store.FindJob("running")
+	if err != nil || found.Usage != nil {
+		t.Errorf("Expected the running job without its usage, but got %+v, %v", found.Usage, err)
+	}
+
+	found, err = FindJobDetails(store, "running")
+	if err != nil || found.Usage == nil || !found.Usage.Sampled {
+		t.Errorf("Expected the running job with its sampled usage, but got %+v, %v", found.Usage, err)
+	}
+}
+
+func TestJobOutputStream(t *testing.T) {
+	output := newJobOutput("job", OutputLimits{})
+	output.Stdout.Write([]byte("before\n"))
+
+	var streamed bytes.Buffer
+	done := make(chan error)
+	go func() {
+		done <- output.Stream(context.Background(), &streamed, StreamBoth, true)
+	}()
+
+	for i := 0; i < 100; i++ {
+		output.Stdout.Write([]byte("out\n"))
+		output.Stderr.Write([]byte("err\n"))
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the stream to follow the output until it is closed, but it returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	output.close()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := streamed.String()
+	if !strings.HasPrefix(content, "before\n") || strings.Count(content, "out\n") != 100 || strings.Count(content, "err\n") != 100 {
+		t.Errorf("Incorrect streamed output: %q", content)
+	}
+
+	var stderr bytes.Buffer
+	err = output.Stream(context.Background(), &stderr, StreamStderr, false)
+	if err != nil || stderr.String() != strings.Repeat("err\n", 100) {
+		t.Errorf("Incorrect stderr: %q, %v", stderr.String(), err)
+	}
+
+	var tail bytes.Buffer
+	err = output.StreamFrom(context.Background(), &tail, StreamStdout, -8, false)
+	if err != nil || tail.String() != "out\nout\n" {
+		t.Errorf("Incorrect end of stdout: %q, %v", tail.String(), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	running := newJobOutput("running", OutputLimits{})
+	err = running.Stream(ctx, ioutil.Discard, StreamStdout, true)
+	if err != context.Canceled {
+		t.Errorf("Expected the stream to end with its context, but got %v", err)
+	}
+}
+
+func BenchmarkOutputBuffer(b *testing.B) {
+	for _, size := range []int{1 << 20, 16 << 20, 256 << 20} {
+		b.Run(fmt.Sprintf("%dMB", size>>20), func(b *testing.B) {
+			benchmarkOutput(b, size, func() io.Writer { return &OutputBuffer{} })
+		})
+	}
+}
+
+// BenchmarkLegacyOutputWriter measures the previous output writer, which kept the output in a strings.Builder
+// and saved it in the store on every write. Growing the builder reallocates and copies the whole output.
+func BenchmarkLegacyOutputWriter(b *testing.B) {
+	for _, size := range []int{1 << 20, 16 << 20, 256 << 20} {
+		b.Run(fmt.Sprintf("%dMB", size>>20), func(b *testing.B) {
+			benchmarkOutput(b, size, func() io.Writer { return &legacyOutputWriter{jobs: make(map[string]string)} })
+		})
+	}
+}
+
+// benchmarkOutput writes size bytes in 32KB writes, the size of the writes of exec.Cmd's copying goroutine
+func benchmarkOutput(b *testing.B, size int, newWriter func() io.Writer) {
+	p := bytes.Repeat([]byte("y\n"), 16*1024)
+	b.SetBytes(int64(size))
+
+	for i := 0; i < b.N; i++ {
+		writer := newWriter()
+		for written := 0; written < size; written += len(p) {
+			writer.Write(p)
+		}
+	}
+}
+
+type legacyOutputWriter struct {
+	result strings.Builder
+	mutex  sync.Mutex
+	jobs   map[string]string
+}
+
+func (w *legacyOutputWriter) Write(p []byte) (int, error) {
+	n, err := w.result.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	w.mutex.Lock()
+	w.jobs["stdout"] = w.result.String()
+	w.mutex.Unlock()
+
+	return n, nil
+}
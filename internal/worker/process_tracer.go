@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"runtime"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// The following ptrace requests and events are missing from the syscall package
+const (
+	ptraceSeize     = 0x4206
+	ptraceListen    = 0x4208
+	ptraceEventStop = 128
+)
+
+// traceExit returns a channel receiving the wait status of a process that is not a child of
+// the server, such as the process of a job started by a previous server. The server attaches
+// to the process with ptrace, since the kernel reports the exit of a traced process to its tracer.
+// The process keeps running and receiving its signals as before.
+// The channel is closed without a status if the exit status could not be collected.
+func traceExit(pid int) (<-chan syscall.WaitStatus, error) {
+	attached := make(chan error)
+	exited := make(chan syscall.WaitStatus, 1)
+
+	go func() {
+		// Every ptrace request must be made by the thread that attached to the process
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, ptraceSeize, uintptr(pid), 0, 0, 0, 0)
+		if errno != 0 {
+			attached <- errors.Wrapf(errno, "Unable to trace process %d", pid)
+			return
+		}
+		attached <- nil
+
+		status, err := waitTracee(pid)
+		if err == nil {
+			exited <- status
+		}
+
+		close(exited)
+	}()
+
+	err := <-attached
+	if err != nil {
+		return nil, err
+	}
+
+	return exited, nil
+}
+
+// waitTracee resumes the traced process each time it stops, until it exits
+func waitTracee(pid int) (syscall.WaitStatus, error) {
+	for {
+		var status syscall.WaitStatus
+		_, err := syscall.Wait4(pid, &status, syscall.WALL, nil)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		if status.Exited() || status.Signaled() {
+			return status, nil
+		}
+
+		if !status.Stopped() {
+			continue
+		}
+
+		if int(status)>>16 == ptraceEventStop {
+			// The process is resumed by SIGCONT after a group-stop, and stops again for its tracer
+			if status.StopSignal() == syscall.SIGTRAP {
+				syscall.PtraceCont(pid, 0)
+				continue
+			}
+
+			// A group-stop, e.g. after SIGSTOP: the process stays stopped until it receives SIGCONT
+			syscall.Syscall6(syscall.SYS_PTRACE, ptraceListen, uintptr(pid), 0, 0, 0, 0)
+			continue
+		}
+
+		// The process has received a signal, which is delivered when it is resumed
+		syscall.PtraceCont(pid, int(status.StopSignal()))
+	}
+}
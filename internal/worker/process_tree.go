@@ -0,0 +1,203 @@
+package worker
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const processPollInterval = 10 * time.Millisecond
+
+// ErrProcessesRunning represents an error returned when the processes of a job are still running after being stopped
+var ErrProcessesRunning = errors.New("worker: The job's processes are still running")
+
+// signalProcesses sends the signal to every process of the job. Each job runs in its own process group,
+// led by the job's process. Processes that leave the group (e.g. daemons calling setsid) can still be
+// found through the job's cgroup, if it has one.
+func (job *Job) signalProcesses(signal syscall.Signal) error {
+	// Signaling process group 0 would signal the API server's own group
+	if job.Pid <= 0 {
+		return errors.New("The job has no process")
+	}
+
+	// Fails with ESRCH once the processes of the group have exited, even if some which have left it are running.
+	// The error is only returned if none of them could be signaled.
+	err := syscall.Kill(-job.Pid, signal)
+
+	group := findCgroup(job.ID)
+	if group == nil {
+		return err
+	}
+
+	pids, _ := group.processes()
+	for _, pid := range pids {
+		if syscall.Kill(pid, signal) == nil && err == syscall.ESRCH {
+			err = nil
+		}
+	}
+
+	return err
+}
+
+// killRemainingProcesses sends SIGKILL to the processes left behind by the job's process once it has exited,
+// and waits for them to exit. They would otherwise outlive the job, and keep its output open.
+func (job *Job) killRemainingProcesses() {
+	alive, err := job.processesAlive()
+	if err != nil || !alive {
+		return
+	}
+
+	err = job.signalProcesses(syscall.SIGKILL)
+	if err == nil {
+		err = job.waitForProcesses(stopTimeout)
+	}
+	if err != nil && err != syscall.ESRCH {
+		log.Println(errors.Wrap(err, "Unable to kill the remaining processes of the job"))
+	}
+}
+
+// waitForProcesses waits until none of the job's processes are left, or the timeout expires
+func (job *Job) waitForProcesses(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		alive, err := job.processesAlive()
+		if err != nil {
+			return err
+		}
+
+		if !alive {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return ErrProcessesRunning
+		}
+
+		time.Sleep(processPollInterval)
+	}
+}
+
+func (job *Job) processesAlive() (bool, error) {
+	group := findCgroup(job.ID)
+	if group != nil {
+		pids, err := group.processes()
+		if err == nil && len(pids) > 0 {
+			return true, nil
+		}
+	}
+
+	return processGroupAlive(job.Pid)
+}
+
+// processGroupAlive returns true if a process of the group has not exited yet.
+// Zombies are ignored, since they are only waiting to be reaped.
+func processGroupAlive(pgid int) (bool, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		state, processGroup, err := processStat(pid)
+		if err != nil {
+			// The process has exited since /proc was read
+			continue
+		}
+
+		if processGroup == pgid && state != "Z" && state != "X" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// processStat returns the state and the process group of a process from /proc/[pid]/stat
+func processStat(pid int) (string, int, error) {
+	fields, err := readProcessStat(pid)
+	if err != nil {
+		return "", 0, err
+	}
+
+	processGroup, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return "", 0, err
+	}
+
+	return fields[0], processGroup, nil
+}
+
+// processStartTime returns when a process started. /proc/[pid]/stat counts it in clock ticks since the boot.
+func processStartTime(pid int) (time.Time, error) {
+	fields, err := readProcessStat(pid)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// The start time is the field 22, and the fields start with the third one
+	if len(fields) < 20 {
+		return time.Time{}, errors.Errorf("Unexpected format of /proc/%d/stat", pid)
+	}
+
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return boot.Add(time.Duration(ticks) * time.Second / clockTicksPerSecond), nil
+}
+
+// readProcessStat returns the fields of /proc/[pid]/stat which follow the command name, starting with the state
+func readProcessStat(pid int) ([]string, error) {
+	content, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name in the second field may contain spaces and parentheses
+	stat := string(content)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 3 {
+		return nil, errors.Errorf("Unexpected format of /proc/%d/stat", pid)
+	}
+
+	return fields, nil
+}
+
+// bootTime returns when the system booted, from its uptime in /proc/uptime
+func bootTime() (time.Time, error) {
+	now := time.Now()
+	content, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return time.Time{}, errors.New("Unexpected format of /proc/uptime")
+	}
+
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return now.Add(-time.Duration(uptime * float64(time.Second))), nil
+}
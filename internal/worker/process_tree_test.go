@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalEscapedProcesses(t *testing.T) {
+	if !cgroupV2Available() || syscall.Geteuid() != 0 {
+		t.Skip("Finding the processes which have left the process group requires cgroup v2 and root")
+	}
+
+	group, err := newCgroup("escaped", ResourceLimits{PidsMax: "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer group.remove()
+
+	// A process which has left the job's process group, which is now empty
+	escaped := startOrphan(t, "sleep", "30")
+	err = group.addProcess(escaped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader := exec.Command("true")
+	err = leader.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &Job{ID: "escaped", Pid: leader.Process.Pid}
+	err = job.signalProcesses(syscall.SIGKILL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = job.waitForProcesses(time.Second)
+	if err != nil {
+		t.Errorf("Expected the process which has left the group to be killed, but got %v", err)
+	}
+}
+
+func TestBackgroundProcesses(t *testing.T) {
+	store := NewMemoryJobStore()
+
+	// The background process keeps the output open after the job's process has exited
+	job := &Job{Args: []string{"sh", "-c", "sleep 300 & echo hi"}}
+	start := time.Now()
+	err := job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != Completed || found.Stdout != "hi\n" || time.Since(start) > stopTimeout {
+		t.Errorf("Expected the job to complete with its process, but got %s, %q after %s", found.Status, found.Stdout, time.Since(start))
+	}
+
+	alive, err := processGroupAlive(job.Pid)
+	if err != nil || alive {
+		t.Errorf("Expected the background process to be killed with the job, but got %t, %v", alive, err)
+	}
+}
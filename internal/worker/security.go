@@ -0,0 +1,267 @@
+package worker
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// The following constants are possible values for the SecurityProfile of a Job
+const (
+	SecurityProfileUnconfined = "unconfined"
+	SecurityProfileDefault    = "default"
+	SecurityProfileStrict     = "strict"
+)
+
+// SecurityProfiles are the security profiles, from the least to the most restrictive
+var SecurityProfiles = []string{SecurityProfileUnconfined, SecurityProfileDefault, SecurityProfileStrict}
+
+// ErrInvalidSecurityProfile represents an error returned when a Job has an unknown SecurityProfile
+var ErrInvalidSecurityProfile = errors.New("worker: Invalid security profile")
+
+// The following constants are the prctl options and seccomp values missing from the syscall package
+const (
+	prSetNoNewPrivs      = 38
+	prSetSeccomp         = 22
+	prCapBSetDrop        = 24
+	seccompModeFilter    = 2
+	seccompRetKillThread = 0x00000000
+	seccompRetErrno      = 0x00050000
+	seccompRetAllow      = 0x7fff0000
+	maxCapability        = 63
+)
+
+// Offsets of the fields of struct seccomp_data read by the filters. The low 32 bits of an argument come
+// first on the little-endian architectures of seccompArchitectures.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16
+)
+
+// defaultCapabilities are the capabilities kept by the default profile, the same as the default of Docker:
+// CHOWN, DAC_OVERRIDE, FOWNER, FSETID, KILL, SETGID, SETUID, SETPCAP, NET_BIND_SERVICE, NET_RAW, SYS_CHROOT,
+// MKNOD, AUDIT_WRITE and SETFCAP. They only matter to the processes which run as root.
+var defaultCapabilities = []uintptr{0, 1, 3, 4, 5, 6, 7, 8, 10, 13, 18, 27, 29, 31}
+
+// defaultDeniedSyscalls fail with EPERM under the default profile: they load kernel code, change the
+// system's time, mounts, swap or keyrings, create namespaces, or expose kernel internals. The profile also
+// denies the creation of namespaces by clone, like Docker does for the processes without CAP_SYS_ADMIN.
+var defaultDeniedSyscalls = []string{
+	"_sysctl", "acct", "add_key", "adjtimex", "bpf", "clock_adjtime", "clock_settime", "create_module",
+	"delete_module", "finit_module", "fsconfig", "fsmount", "fsopen", "fspick", "get_kernel_syms",
+	"init_module", "ioperm", "iopl", "kexec_file_load", "kexec_load", "keyctl", "lookup_dcookie", "mount",
+	"mount_setattr", "move_mount", "name_to_handle_at", "nfsservctl", "open_by_handle_at", "open_tree",
+	"perf_event_open", "pivot_root", "query_module", "quotactl", "reboot", "request_key", "setns",
+	"settimeofday", "swapoff", "swapon", "sysfs", "syslog", "umount2", "unshare", "uselib", "userfaultfd",
+	"ustat", "vhangup",
+}
+
+// strictDeniedSyscalls also fail with EPERM under the strict profile: the processes cannot trace or read
+// the memory of each other, create devices, change their root or use io_uring
+var strictDeniedSyscalls = []string{
+	"chroot", "io_uring_enter", "io_uring_register", "io_uring_setup", "kcmp", "mknod", "mknodat",
+	"process_vm_readv", "process_vm_writev", "ptrace",
+}
+
+// namespaceFlags are the clone flags creating new namespaces
+const namespaceFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER |
+	syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | 0x02000000 // CLONE_NEWCGROUP
+
+// seccompArch is an architecture supported by the seccomp filters: its AUDIT_ARCH value and the numbers
+// of its system calls. x32 is set if system calls with the x32 bit must be denied.
+type seccompArch struct {
+	audit    uint32
+	x32      bool
+	syscalls map[string]uint32
+}
+
+// seccompArchitectures are the architectures supported by the seccomp filters, by GOARCH
+var seccompArchitectures = map[string]seccompArch{
+	"amd64": {
+		audit: 0xc000003e,
+		x32:   true,
+		syscalls: map[string]uint32{
+			"_sysctl": 156, "acct": 163, "add_key": 248, "adjtimex": 159, "bpf": 321, "chroot": 161,
+			"clock_adjtime": 305, "clock_settime": 227, "clone": 56, "clone3": 435, "create_module": 174,
+			"delete_module": 176, "finit_module": 313, "fsconfig": 431, "fsmount": 432, "fsopen": 430,
+			"fspick": 433, "get_kernel_syms": 177, "init_module": 175, "io_uring_enter": 426,
+			"io_uring_register": 427, "io_uring_setup": 425, "ioperm": 173, "iopl": 172, "kcmp": 312,
+			"kexec_file_load": 320, "kexec_load": 246, "keyctl": 250, "lookup_dcookie": 212, "mknod": 133,
+			"mknodat": 259, "mount": 165, "mount_setattr": 442, "move_mount": 429, "name_to_handle_at": 303,
+			"nfsservctl": 180, "open_by_handle_at": 304, "open_tree": 428, "perf_event_open": 298,
+			"pivot_root": 155, "process_vm_readv": 310, "process_vm_writev": 311, "ptrace": 101,
+			"query_module": 178, "quotactl": 179, "reboot": 169, "request_key": 249, "setns": 308,
+			"settimeofday": 164, "swapoff": 168, "swapon": 167, "sysfs": 139, "syslog": 103, "umount2": 166,
+			"unshare": 272, "uselib": 134, "userfaultfd": 323, "ustat": 136, "vhangup": 153,
+		},
+	},
+	"arm64": {
+		audit: 0xc00000b7,
+		syscalls: map[string]uint32{
+			"acct": 89, "add_key": 217, "adjtimex": 171, "bpf": 280, "chroot": 51, "clock_adjtime": 266,
+			"clock_settime": 112, "clone": 220, "clone3": 435, "delete_module": 106, "finit_module": 273,
+			"fsconfig": 431, "fsmount": 432, "fsopen": 430, "fspick": 433, "init_module": 105,
+			"io_uring_enter": 426, "io_uring_register": 427, "io_uring_setup": 425, "kcmp": 272,
+			"kexec_file_load": 294, "kexec_load": 104, "keyctl": 219, "lookup_dcookie": 18, "mknodat": 33,
+			"mount": 40, "mount_setattr": 442, "move_mount": 429, "name_to_handle_at": 264, "nfsservctl": 42,
+			"open_by_handle_at": 265, "open_tree": 428, "perf_event_open": 241, "pivot_root": 41,
+			"process_vm_readv": 270, "process_vm_writev": 271, "ptrace": 117, "quotactl": 60, "reboot": 142,
+			"request_key": 218, "setns": 268, "settimeofday": 170, "swapoff": 225, "swapon": 224,
+			"syslog": 116, "umount2": 39, "unshare": 97, "userfaultfd": 282, "vhangup": 58,
+		},
+	},
+}
+
+func validSecurityProfile(profile string) bool {
+	for _, valid := range SecurityProfiles {
+		if profile == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dropCapabilities runs in the child, before it switches to the job's credential. Except for the unconfined
+// profile, it drops the capabilities that the profile does not keep from the bounding set, so that the
+// command cannot get them even as root. A process which is not root and has no capabilities cannot get
+// any once restrictSyscalls has set no_new_privs, so its bounding set is left as is.
+func dropCapabilities(profile string) error {
+	if profile == SecurityProfileUnconfined {
+		return nil
+	}
+
+	if syscall.Geteuid() != 0 && !hasCapabilities() {
+		return nil
+	}
+
+	var keep []uintptr
+	if profile == SecurityProfileDefault {
+		keep = defaultCapabilities
+	}
+
+	for capability := uintptr(0); capability <= maxCapability; capability++ {
+		if containsCapability(keep, capability) {
+			continue
+		}
+
+		_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapBSetDrop, capability, 0)
+		if errno == syscall.EINVAL {
+			// The capabilities after the last one of the kernel
+			break
+		} else if errno != 0 {
+			return errors.Wrapf(errno, "Unable to drop capability %d", capability)
+		}
+	}
+
+	return nil
+}
+
+// restrictSyscalls runs in the child just before the exec. Except for the unconfined profile, it sets
+// no_new_privs, so that the command cannot gain privileges by running a setuid binary, and installs the
+// profile's seccomp filter.
+func restrictSyscalls(profile string) error {
+	if profile == SecurityProfileUnconfined {
+		return nil
+	}
+
+	filter, err := seccompFilter(profile)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "Unable to set no_new_privs")
+	}
+
+	program := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno = syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&program)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return errors.Wrap(errno, "Unable to install seccomp filter")
+	}
+
+	return nil
+}
+
+// seccompFilter returns the BPF program of the profile's seccomp filter. A system call of another
+// architecture kills the thread, and clone3 fails with ENOSYS so that the C library falls back to clone,
+// whose flags can be checked.
+func seccompFilter(profile string) ([]syscall.SockFilter, error) {
+	arch, ok := seccompArchitectures[runtime.GOARCH]
+	if !ok {
+		return nil, errors.Errorf("Seccomp filters are not supported on %s", runtime.GOARCH)
+	}
+
+	denied := defaultDeniedSyscalls
+	if profile == SecurityProfileStrict {
+		denied = append(append([]string{}, defaultDeniedSyscalls...), strictDeniedSyscalls...)
+	}
+
+	errno := func(err syscall.Errno) syscall.SockFilter {
+		return bpfStatement(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(err))
+	}
+
+	filter := []syscall.SockFilter{
+		bpfStatement(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch),
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, arch.audit, 1, 0),
+		bpfStatement(syscall.BPF_RET|syscall.BPF_K, seccompRetKillThread),
+		bpfStatement(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr),
+	}
+
+	if arch.x32 {
+		filter = append(filter, bpfJump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, 0x40000000, 0, 1), errno(syscall.ENOSYS))
+	}
+
+	filter = append(filter, bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, arch.syscalls["clone3"], 0, 1), errno(syscall.ENOSYS))
+
+	for _, name := range denied {
+		number, ok := arch.syscalls[name]
+		if !ok {
+			// The system call does not exist on this architecture
+			continue
+		}
+
+		filter = append(filter, bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, number, 0, 1), errno(syscall.EPERM))
+	}
+
+	filter = append(filter,
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, arch.syscalls["clone"], 0, 3),
+		bpfStatement(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArg0),
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, namespaceFlags, 0, 1),
+		errno(syscall.EPERM),
+	)
+
+	return append(filter, bpfStatement(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow)), nil
+}
+
+func bpfStatement(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jumpTrue, jumpFalse uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jumpTrue, Jf: jumpFalse, K: k}
+}
+
+// hasCapabilities returns true if the current thread has permitted capabilities
+func hasCapabilities() bool {
+	header := capUserHeader{version: linuxCapabilityVersion3}
+	var data [2]capUserData
+	_, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+
+	return errno != 0 || data[0].permitted != 0 || data[1].permitted != 0
+}
+
+func containsCapability(capabilities []uintptr, capability uintptr) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// cloneHelperEnv makes the test binary create a user namespace with clone, then exit, when it is run by a job
+const cloneHelperEnv = "WORKER_TEST_CLONE_HELPER=1"
+
+func init() {
+	if os.Getenv("WORKER_TEST_CLONE_HELPER") != "1" {
+		return
+	}
+
+	attr := &syscall.ProcAttr{Sys: &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}}
+	_, err := syscall.ForkExec("/bin/true", []string{"true"}, attr)
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println("cloned")
+	}
+
+	os.Exit(0)
+}
+
+func TestSecurityProfiles(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Checking the capabilities of a job requires root")
+	}
+
+	store := NewMemoryJobStore()
+
+	// unshare fails under the seccomp filters of the default and strict profiles
+	command := "grep -E '^(CapBnd|NoNewPrivs|Seccomp):' /proc/self/status; unshare -U true && echo unshared"
+	expected := map[string]string{
+		SecurityProfileUnconfined: "NoNewPrivs:\t0\nSeccomp:\t0\nunshared\n",
+		SecurityProfileDefault:    "CapBnd:\t00000000a80425fb\nNoNewPrivs:\t1\nSeccomp:\t2\n",
+		SecurityProfileStrict:     "CapBnd:\t0000000000000000\nNoNewPrivs:\t1\nSeccomp:\t2\n",
+	}
+
+	for profile, output := range expected {
+		job := &Job{Args: []string{"sh", "-c", command}, SecurityProfile: profile}
+		err := job.Start(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := waitForFinalStatus(store, job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The bounding set of an unconfined job is the one of the server
+		stdout := found.Stdout
+		if profile == SecurityProfileUnconfined {
+			stdout = stdout[strings.Index(stdout, "\n")+1:]
+		}
+
+		if stdout != output || found.SecurityProfile != profile {
+			t.Errorf("Expected the %s profile to output %q, but got %q, %q", profile, output, found.Stdout, found.Stderr)
+		}
+	}
+
+	// clone fails with the flags creating namespaces under the default and strict profiles
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = map[string]string{
+		SecurityProfileUnconfined: "cloned\n",
+		SecurityProfileDefault:    "operation not permitted\n",
+		SecurityProfileStrict:     "operation not permitted\n",
+	}
+
+	for profile, output := range expected {
+		job := &Job{Args: []string{executable}, Env: []string{cloneHelperEnv}, SecurityProfile: profile}
+		err = job.Start(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := waitForFinalStatus(store, job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found.Stdout != output {
+			t.Errorf("Expected clone to output %q under the %s profile, but got %q, %q", output, profile, found.Stdout, found.Stderr)
+		}
+	}
+
+	job := &Job{Args: []string{"true"}}
+	err = job.Start(store)
+	if err != nil || job.SecurityProfile != SecurityProfileDefault {
+		t.Errorf("Expected the job to have the default profile, but got %q, %v", job.SecurityProfile, err)
+	}
+
+	job = &Job{Args: []string{"true"}, SecurityProfile: "relaxed"}
+	err = job.Start(store)
+	if !errors.Is(err, ErrInvalidSecurityProfile) {
+		t.Errorf("Expected ErrInvalidSecurityProfile, but got %v", err)
+	}
+}
+
+func TestSecurityProfileIsolation(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating namespaces requires root")
+	}
+
+	store := NewMemoryJobStore()
+
+	// The profile is applied once the namespaces have been set up, which needs the system calls it denies
+	job := &Job{Args: []string{"sh", "-c", "grep Seccomp: /proc/self/status; hostname"}, SecurityProfile: SecurityProfileStrict, Isolation: IsolationNamespaces}
+	err := job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != Completed || found.Stdout != "Seccomp:\t2\n"+job.ID+"\n" {
+		t.Errorf("Expected an isolated job to run under the strict profile, but got %s with %q, %q", found.Status, found.Stdout, found.Stderr)
+	}
+}
+
+func TestChildEnvironment(t *testing.T) {
+	store := NewMemoryJobStore()
+
+	// The loader warns about the missing library once, when it runs the command but not the child
+	job := &Job{Args: []string{"true"}, Env: []string{"LD_PRELOAD=/nonexistent/preload.so"}}
+	err := job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Count(found.Stderr, "preload.so") != 1 {
+		t.Errorf("Expected only the command to get the job's environment, but got %q", found.Stderr)
+	}
+}
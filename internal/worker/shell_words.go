@@ -0,0 +1,98 @@
+package worker
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// splitShellWords splits a command into arguments following the POSIX shell quoting rules.
+// Single quotes, double quotes and backslash escapes are supported. Unlike a shell, it does
+// not expand variables, globs or any other special characters.
+func splitShellWords(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case c == '\\':
+			i++
+			if i == len(command) {
+				return nil, errors.New("Unterminated escape")
+			}
+
+			// A backslash-newline is a line continuation
+			if command[i] != '\n' {
+				word.WriteByte(command[i])
+				inWord = true
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end == -1 {
+				return nil, errors.New("Unterminated single quote")
+			}
+
+			word.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+
+		case c == '"':
+			next, err := readDoubleQuoted(command, i+1, &word)
+			if err != nil {
+				return nil, err
+			}
+
+			i = next
+			inWord = true
+
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// readDoubleQuoted writes the content of a double-quoted string starting at index start to word.
+// Returns the index of the closing quote.
+func readDoubleQuoted(command string, start int, word *strings.Builder) (int, error) {
+	for i := start; i < len(command); i++ {
+		c := command[i]
+
+		switch c {
+		case '"':
+			return i, nil
+
+		case '\\':
+			if i+1 < len(command) && strings.IndexByte("$`\"\\\n", command[i+1]) != -1 {
+				i++
+				if command[i] != '\n' {
+					word.WriteByte(command[i])
+				}
+			} else {
+				word.WriteByte(c)
+			}
+
+		default:
+			word.WriteByte(c)
+		}
+	}
+
+	return 0, errors.New("Unterminated double quote")
+}
@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{`ls`, []string{"ls"}},
+		{`ls   -l  /tmp `, []string{"ls", "-l", "/tmp"}},
+		{`echo "hello world"`, []string{"echo", "hello world"}},
+		{`echo 'it'\''s' 'a\b'`, []string{"echo", "it's", `a\b`}},
+		{`echo 'a "b" c'`, []string{"echo", `a "b" c`}},
+		{`echo "a \"b\" \$HOME \n"`, []string{"echo", `a "b" $HOME \n`}},
+		{`echo hello\ world \*`, []string{"echo", "hello world", "*"}},
+		{`echo "" ''`, []string{"echo", "", ""}},
+		{`echo a"b c"'d'`, []string{"echo", "ab cd"}},
+		{"echo a\\\nb", []string{"echo", "ab"}},
+		{`echo $HOME *.go`, []string{"echo", "$HOME", "*.go"}},
+	}
+
+	for _, test := range tests {
+		words, err := splitShellWords(test.command)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.command, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(words, test.expected) {
+			t.Errorf("Incorrect words for %s.\nExpected: %q\nGot: %q", test.command, test.expected, words)
+		}
+	}
+}
+
+func TestSplitShellWordsErrors(t *testing.T) {
+	commands := []string{`echo "hello`, `echo 'hello`, `echo hello\`}
+
+	for _, command := range commands {
+		_, err := splitShellWords(command)
+		if err == nil {
+			t.Errorf("Expected an error for %s", command)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"strconv"
+	"syscall"
+)
+
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGABRT:  "SIGABRT",
+	syscall.SIGALRM:  "SIGALRM",
+	syscall.SIGBUS:   "SIGBUS",
+	syscall.SIGCHLD:  "SIGCHLD",
+	syscall.SIGCONT:  "SIGCONT",
+	syscall.SIGFPE:   "SIGFPE",
+	syscall.SIGHUP:   "SIGHUP",
+	syscall.SIGILL:   "SIGILL",
+	syscall.SIGINT:   "SIGINT",
+	syscall.SIGKILL:  "SIGKILL",
+	syscall.SIGPIPE:  "SIGPIPE",
+	syscall.SIGPROF:  "SIGPROF",
+	syscall.SIGQUIT:  "SIGQUIT",
+	syscall.SIGSEGV:  "SIGSEGV",
+	syscall.SIGSTOP:  "SIGSTOP",
+	syscall.SIGSYS:   "SIGSYS",
+	syscall.SIGTERM:  "SIGTERM",
+	syscall.SIGTRAP:  "SIGTRAP",
+	syscall.SIGTSTP:  "SIGTSTP",
+	syscall.SIGTTIN:  "SIGTTIN",
+	syscall.SIGTTOU:  "SIGTTOU",
+	syscall.SIGURG:   "SIGURG",
+	syscall.SIGUSR1:  "SIGUSR1",
+	syscall.SIGUSR2:  "SIGUSR2",
+	syscall.SIGWINCH: "SIGWINCH",
+	syscall.SIGXCPU:  "SIGXCPU",
+	syscall.SIGXFSZ:  "SIGXFSZ",
+}
+
+// signalName returns the name of the signal, e.g. "SIGTERM"
+func signalName(signal syscall.Signal) string {
+	name, ok := signalNames[signal]
+	if !ok {
+		return "SIG" + strconv.Itoa(int(signal))
+	}
+
+	return name
+}
+
+// terminationSignal returns the name of the signal that terminated the process, or an empty string
+// if the process exited on its own
+func terminationSignal(status syscall.WaitStatus) string {
+	if !status.Signaled() {
+		return ""
+	}
+
+	return signalName(status.Signal())
+}
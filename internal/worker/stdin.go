@@ -0,0 +1,147 @@
+package worker
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidStdin represents an error returned when the stdin of a Job cannot be set up as requested
+var ErrInvalidStdin = errors.New("worker: Invalid stdin")
+
+// ErrStdinClosed represents an error returned when writing to the stdin of a job which has been closed,
+// or which was not started with OpenStdin
+var ErrStdinClosed = errors.New("worker: The job's stdin is closed")
+
+// ErrStdinBusy represents an error returned when writing to the stdin of a job while another write is in progress
+var ErrStdinBusy = errors.New("worker: The job's stdin is already being written")
+
+// validateStdin checks that the job gets its input from a single source
+func (job *Job) validateStdin() error {
+	switch {
+	case job.Input != nil && job.OpenStdin:
+		return errors.WithMessage(ErrInvalidStdin, "Input and OpenStdin cannot both be set")
+	case job.TTY && (job.Input != nil || job.OpenStdin):
+		return errors.WithMessage(ErrInvalidStdin, "A job with TTY reads its input from its terminal")
+	}
+
+	return nil
+}
+
+// setStdin sets the stdin of the command. For a job started with OpenStdin, returns the pipe
+// whose reader is the stdin.
+func (job *Job) setStdin(cmd *exec.Cmd) (*os.File, *os.File, error) {
+	if job.OpenStdin {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cmd.Stdin = reader
+		return reader, writer, nil
+	}
+
+	if job.Input != nil {
+		cmd.Stdin = job.Input
+	}
+
+	return nil, nil, nil
+}
+
+// closeInput closes the Input of the job, once its process has exited or could not be started
+func (job *Job) closeInput() {
+	closer, ok := job.Input.(io.Closer)
+	if !ok {
+		return
+	}
+
+	err := closer.Close()
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// WriteStdin copies r to the stdin of a running job started with OpenStdin, and returns the number
+// of bytes written. If closeStdin is set, the stdin is closed once all of r has been written, which sends
+// EOF to the job. Only one write can be in progress at a time. Returns ErrStdinClosed if the stdin
+// has been closed, including by the job's process, and ErrJobNotRunning if the process has exited.
+func (job *Job) WriteStdin(r io.Reader, closeStdin bool) (int64, error) {
+	if !job.OpenStdin {
+		return 0, ErrStdinClosed
+	}
+
+	process := findProcess(job.ID)
+	if process == nil {
+		return 0, ErrJobNotRunning
+	}
+
+	stdin, err := process.acquireStdin()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(stdin, r)
+	process.releaseStdin(closeStdin && err == nil)
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrClosed) {
+		return n, ErrStdinClosed
+	} else if err != nil {
+		return n, errors.Wrap(err, "Unable to write to job's stdin")
+	}
+
+	return n, nil
+}
+
+// setStdin sets the writer of the stdin of a job started with OpenStdin
+func (process *jobProcess) setStdin(stdin *os.File) {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	process.stdin = stdin
+}
+
+// acquireStdin returns the writer of the stdin, which is reserved until releaseStdin is called
+func (process *jobProcess) acquireStdin() (*os.File, error) {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.stdin == nil {
+		return nil, ErrStdinClosed
+	}
+
+	if process.stdinBusy {
+		return nil, ErrStdinBusy
+	}
+
+	process.stdinBusy = true
+	return process.stdin, nil
+}
+
+// releaseStdin ends the write which has acquired the stdin, and closes the stdin if closeStdin is set
+func (process *jobProcess) releaseStdin(closeStdin bool) {
+	process.mutex.Lock()
+	process.stdinBusy = false
+	process.mutex.Unlock()
+
+	if closeStdin {
+		process.closeStdin()
+	}
+}
+
+// closeStdin closes the writer of the stdin, if it is still open
+func (process *jobProcess) closeStdin() {
+	process.mutex.Lock()
+	stdin := process.stdin
+	process.stdin = nil
+	process.mutex.Unlock()
+
+	if stdin != nil {
+		err := stdin.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestStdin(t *testing.T) {
+	store := NewMemoryJobStore()
+
+	job := &Job{Args: []string{"cat"}, Input: strings.NewReader("from input\n")}
+	err := job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != Completed || found.Stdout != "from input\n" {
+		t.Errorf("Expected the job to read its Input, but got %s with output %q", found.Status, found.Stdout)
+	}
+
+	job = &Job{Args: []string{"cat"}, OpenStdin: true}
+	err = job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, closeStdin := range []bool{false, true} {
+		n, err := job.WriteStdin(strings.NewReader("line\n"), closeStdin)
+		if err != nil || n != 5 {
+			t.Fatalf("Expected write %d to the stdin to succeed, but got %d bytes, %v", i, n, err)
+		}
+	}
+
+	found, err = waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != Completed || found.Stdout != "line\nline\n" {
+		t.Errorf("Expected the job to exit at the end of its stdin, but got %s with output %q", found.Status, found.Stdout)
+	}
+
+	_, err = job.WriteStdin(strings.NewReader("late\n"), true)
+	if !errors.Is(err, ErrJobNotRunning) && !errors.Is(err, ErrStdinClosed) {
+		t.Errorf("Expected the stdin to be closed, but got %v", err)
+	}
+
+	invalidJobs := []*Job{
+		{Args: []string{"cat"}, OpenStdin: true, Input: strings.NewReader("")},
+		{Args: []string{"cat"}, TTY: true, OpenStdin: true},
+	}
+	for _, job := range invalidJobs {
+		err = job.Start(store)
+		if !errors.Is(err, ErrInvalidStdin) {
+			t.Errorf("Expected ErrInvalidStdin, but got %v", err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// The following constants are the size of a job's terminal until a client resizes it
+const (
+	defaultTerminalRows = 24
+	defaultTerminalCols = 80
+)
+
+// ErrNoTerminal represents an error returned when a job which was not started with TTY is used as a terminal
+var ErrNoTerminal = errors.New("worker: The job does not have a terminal")
+
+// winsize is the struct used by the TIOCSWINSZ ioctl
+type winsize struct {
+	rows   uint16
+	cols   uint16
+	xpixel uint16
+	ypixel uint16
+}
+
+// jobTerminal is the pseudo-terminal of a job started with TTY. The job's process runs with the slave
+// side as its controlling terminal and its standard streams. The server reads the job's output from the
+// master side, and writes the job's input to it. copied is closed once all the output has been read.
+type jobTerminal struct {
+	master *os.File
+	slave  *os.File
+	copied chan struct{}
+}
+
+// openTerminal opens a new pseudo-terminal with the default size
+func openTerminal() (*jobTerminal, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var unlock int32
+	err = fileIoctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock))
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	var number uint32
+	err = fileIoctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number))
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	terminal := &jobTerminal{master: master, slave: slave, copied: make(chan struct{})}
+	err = terminal.resize(defaultTerminalRows, defaultTerminalCols)
+	if err != nil {
+		terminal.close()
+		return nil, err
+	}
+
+	return terminal, nil
+}
+
+// copyOutput copies the output of the job to w until every process has closed the slave side
+func (terminal *jobTerminal) copyOutput(w io.Writer) {
+	defer close(terminal.copied)
+
+	_, err := io.Copy(w, terminal.master)
+	if err != nil && !errors.Is(err, syscall.EIO) {
+		log.Println(err)
+	}
+}
+
+func (terminal *jobTerminal) resize(rows, cols uint16) error {
+	size := winsize{rows: rows, cols: cols}
+	return fileIoctl(terminal.master, syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+}
+
+// closeSlave closes the parent's copy of the slave side once the process has started, so that
+// reading the master side ends when the job's processes have exited
+func (terminal *jobTerminal) closeSlave() {
+	terminal.slave.Close()
+}
+
+func (terminal *jobTerminal) close() {
+	terminal.slave.Close()
+	terminal.master.Close()
+}
+
+// fileIoctl runs an ioctl whose argument is a pointer on the file
+func fileIoctl(file *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+
+	if errno != 0 {
+		return os.NewSyscallError("ioctl", errno)
+	}
+
+	return nil
+}
+
+// Terminal gives access to the terminal of a running job started with TTY:
+// what is written to it is the input of the job.
+type Terminal struct {
+	terminal *jobTerminal
+}
+
+// Terminal returns the terminal of the job. Returns ErrNoTerminal if the job was not started with TTY,
+// and ErrJobNotRunning if its process has exited.
+func (job *Job) Terminal() (*Terminal, error) {
+	if !job.TTY {
+		return nil, ErrNoTerminal
+	}
+
+	process := findProcess(job.ID)
+	if process == nil {
+		return nil, ErrJobNotRunning
+	}
+
+	terminal := process.getTerminal()
+	if terminal == nil {
+		return nil, ErrJobNotRunning
+	}
+
+	return &Terminal{terminal: terminal}, nil
+}
+
+// Write writes p to the job's input, as if it were typed on the terminal
+func (terminal *Terminal) Write(p []byte) (int, error) {
+	return terminal.terminal.master.Write(p)
+}
+
+// Resize changes the size of the terminal. The job's processes are sent SIGWINCH.
+func (terminal *Terminal) Resize(rows, cols uint16) error {
+	return terminal.terminal.resize(rows, cols)
+}
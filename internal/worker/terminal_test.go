@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestTerminal(t *testing.T) {
+	store := NewMemoryJobStore()
+
+	// The command prints its terminal and size, then echoes a line read from the terminal and the new size
+	job := &Job{Args: []string{"sh", "-c", "tty; stty size; read line; echo got $line; stty size"}, TTY: true}
+	err := job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	terminal, err := job.Terminal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := store.FindOutput(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = waitForOutput(output.Stdout, "24 80")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = terminal.Resize(40, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = terminal.Write([]byte("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := waitForFinalStatus(store, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Status != Completed {
+		t.Errorf("Expected the job to be completed, but got %s", found.Status)
+	}
+
+	if !strings.Contains(found.Stdout, "/dev/pts/") || !strings.Contains(found.Stdout, "got hello\r\n40 100") {
+		t.Errorf("Expected the output to come from the terminal, but got %q", found.Stdout)
+	}
+
+	if found.Stderr != "" {
+		t.Errorf("Expected the error output to be written to the terminal, but got %q", found.Stderr)
+	}
+
+	_, err = found.Terminal()
+	if !errors.Is(err, ErrJobNotRunning) {
+		t.Errorf("Expected ErrJobNotRunning once the job has finished, but got %v", err)
+	}
+
+	job = &Job{Args: []string{"true"}}
+	err = job.Start(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = job.Terminal()
+	if !errors.Is(err, ErrNoTerminal) {
+		t.Errorf("Expected ErrNoTerminal for a job without TTY, but got %v", err)
+	}
+}
+
+// waitForOutput waits until the output contains s
+func waitForOutput(buffer *OutputBuffer, s string) error {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if strings.Contains(buffer.String(), s) {
+			return nil
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return errors.Errorf("The output does not contain %q: %q", s, buffer.String())
+}
@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// clockTicksPerSecond is the unit of the CPU times in /proc/[pid]/stat, which is 100 on every Linux architecture
+const clockTicksPerSecond = 100
+
+// Usage contains the resources used by a Job. MaxRSS is the peak memory of the job's largest process,
+// or of the whole job when it has a cgroup. ReadBytes and WriteBytes count the block device IO.
+// Once the job has finished, the usage includes every process of the job which has been waited for.
+// Sampled is set for the usage of a running job, which only includes its current processes and the
+// processes they have waited for.
+type Usage struct {
+	UserCPU                    Duration
+	SystemCPU                  Duration
+	MaxRSS                     int64
+	ReadBytes                  int64
+	WriteBytes                 int64
+	VoluntaryContextSwitches   int64
+	InvoluntaryContextSwitches int64
+	Sampled                    bool
+}
+
+// rusageUsage returns the usage of a process and its waited-for children given by wait4
+func rusageUsage(rusage *syscall.Rusage) *Usage {
+	return &Usage{
+		UserCPU:                    Duration(time.Duration(rusage.Utime.Nano())),
+		SystemCPU:                  Duration(time.Duration(rusage.Stime.Nano())),
+		MaxRSS:                     rusage.Maxrss * 1024,
+		ReadBytes:                  rusage.Inblock * 512,
+		WriteBytes:                 rusage.Oublock * 512,
+		VoluntaryContextSwitches:   rusage.Nvcsw,
+		InvoluntaryContextSwitches: rusage.Nivcsw,
+	}
+}
+
+// addCgroupStats replaces the values of the usage with the statistics of the job's cgroup, which
+// include every process of the job. Statistics missing from the cgroup are left unchanged.
+func (usage *Usage) addCgroupStats(group *cgroup) {
+	cpu := group.readStats("cpu.stat")
+	if userUsec, ok := cpu["user_usec"]; ok {
+		usage.UserCPU = Duration(time.Duration(userUsec) * time.Microsecond)
+	}
+	if systemUsec, ok := cpu["system_usec"]; ok {
+		usage.SystemCPU = Duration(time.Duration(systemUsec) * time.Microsecond)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(group.path, "memory.peak"))
+	if err == nil {
+		peak, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+		if err == nil {
+			usage.MaxRSS = peak
+		}
+	}
+
+	io := group.readStats("io.stat")
+	if rbytes, ok := io["rbytes"]; ok {
+		usage.ReadBytes = rbytes
+	}
+	if wbytes, ok := io["wbytes"]; ok {
+		usage.WriteBytes = wbytes
+	}
+}
+
+// readStats reads a cgroup file of "key value" lines, or of "device key=value..." lines as in io.stat.
+// The values of each key are added up.
+func (group *cgroup) readStats(name string) map[string]int64 {
+	stats := make(map[string]int64)
+
+	content, err := ioutil.ReadFile(filepath.Join(group.path, name))
+	if err != nil {
+		return stats
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && !strings.Contains(fields[1], "=") {
+			value, err := strconv.ParseInt(fields[1], 10, 64)
+			if err == nil {
+				stats[fields[0]] += value
+			}
+			continue
+		}
+
+		for _, field := range fields {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			value, err := strconv.ParseInt(parts[1], 10, 64)
+			if err == nil {
+				stats[parts[0]] += value
+			}
+		}
+	}
+
+	return stats
+}
+
+// sampleUsage returns the current usage of the job's processes
+func (job *Job) sampleUsage() *Usage {
+	usage := &Usage{Sampled: true}
+
+	for _, pid := range job.processIDs() {
+		usage.addProcess(pid)
+	}
+
+	group := findCgroup(job.ID)
+	if group != nil {
+		usage.addCgroupStats(group)
+	}
+
+	return usage
+}
+
+// processIDs returns the pids of the job's running processes, in its process group or its cgroup
+func (job *Job) processIDs() []int {
+	found := make(map[int]bool)
+	var pids []int
+
+	if job.Pid > 0 {
+		entries, _ := ioutil.ReadDir("/proc")
+		for _, entry := range entries {
+			pid, err := strconv.Atoi(entry.Name())
+			if err != nil {
+				continue
+			}
+
+			_, processGroup, err := processStat(pid)
+			if err == nil && processGroup == job.Pid {
+				found[pid] = true
+				pids = append(pids, pid)
+			}
+		}
+	}
+
+	group := findCgroup(job.ID)
+	if group != nil {
+		groupPids, _ := group.processes()
+		for _, pid := range groupPids {
+			if !found[pid] {
+				pids = append(pids, pid)
+			}
+		}
+	}
+
+	return pids
+}
+
+// addProcess adds the usage of a running process read from /proc. Processes which have exited
+// since they were found are ignored.
+func (usage *Usage) addProcess(pid int) {
+	procPath := filepath.Join("/proc", strconv.Itoa(pid))
+
+	content, err := ioutil.ReadFile(filepath.Join(procPath, "stat"))
+	if err != nil {
+		return
+	}
+
+	// The fields following the command name start with the state, which is the third field
+	stat := string(content)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 15 {
+		return
+	}
+
+	ticks := func(index int) time.Duration {
+		value, _ := strconv.ParseInt(fields[index], 10, 64)
+		return time.Duration(value) * time.Second / clockTicksPerSecond
+	}
+
+	// utime, stime, cutime and cstime are the fields 14 to 17
+	usage.UserCPU += Duration(ticks(11) + ticks(13))
+	usage.SystemCPU += Duration(ticks(12) + ticks(14))
+
+	status := readProcFields(filepath.Join(procPath, "status"))
+	if peak := status["VmHWM"] * 1024; peak > usage.MaxRSS {
+		usage.MaxRSS = peak
+	}
+	usage.VoluntaryContextSwitches += status["voluntary_ctxt_switches"]
+	usage.InvoluntaryContextSwitches += status["nonvoluntary_ctxt_switches"]
+
+	io := readProcFields(filepath.Join(procPath, "io"))
+	usage.ReadBytes += io["read_bytes"]
+	usage.WriteBytes += io["write_bytes"]
+}
+
+// readProcFields reads the numeric values of a /proc file of "key: value" lines.
+// Units such as "kB" are ignored.
+func readProcFields(path string) map[string]int64 {
+	values := make(map[string]int64)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		fields := strings.Fields(parts[1])
+		if len(fields) == 0 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[0], 10, 64)
+		if err == nil {
+			values[parts[0]] = value
+		}
+	}
+
+	return values
+}